@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/davidojo1144/LogStream/shared/auth"
+	"github.com/davidojo1144/LogStream/shared/logquery"
+	"github.com/davidojo1144/LogStream/shared/serve"
+)
+
+type Config struct {
+	Server struct {
+		Addr string `yaml:"addr" env:"SERVER_ADDR" flag:"addr" usage:"HTTP listen address"`
+	} `yaml:"server"`
+
+	TLS serve.TLSConfig `yaml:"tls"`
+
+	Health struct {
+		Timeout time.Duration `yaml:"timeout" env:"HEALTH_TIMEOUT" flag:"health-timeout" usage:"Per-dependency timeout for /readyz checks"`
+	} `yaml:"health"`
+
+	ClickHouse struct {
+		Addr     string `yaml:"addr" env:"CLICKHOUSE_ADDR" flag:"clickhouse-addr" usage:"ClickHouse native protocol address"`
+		Database string `yaml:"database" env:"CLICKHOUSE_DATABASE" flag:"clickhouse-database" usage:"ClickHouse database"`
+		Username string `yaml:"username" env:"CLICKHOUSE_USERNAME" flag:"clickhouse-username" usage:"ClickHouse user"`
+		Password string `yaml:"password" env:"CLICKHOUSE_PASSWORD" usage:"ClickHouse password" secret:"true"`
+	} `yaml:"clickhouse"`
+
+	Auth auth.Config `yaml:"auth"`
+
+	Export logquery.ExportConfig `yaml:"export"`
+
+	// Session verifies tokens of users signed in to the web app. Without
+	// a secret only API keys can read.
+	Session struct {
+		Secret string `yaml:"secret" env:"NEXTAUTH_SECRET" usage:"The web app's NEXTAUTH_SECRET, to accept its session tokens" secret:"true"`
+	} `yaml:"session"`
+
+	CORS struct {
+		AllowedOrigins []string `yaml:"allowed_origins" env:"CORS_ALLOWED_ORIGINS" flag:"cors-allowed-origins" usage:"Comma-separated browser origins allowed to call the API, or *"`
+	} `yaml:"cors"`
+
+	// Postgres holds the API keys. Without it only web app sessions can
+	// read.
+	Postgres struct {
+		URL string `yaml:"url" env:"DATABASE_URL" flag:"database-url" usage:"Postgres connection string for API keys" secret:"true"`
+	} `yaml:"postgres"`
+}
+
+func defaultConfig() *Config {
+	cfg := &Config{}
+	cfg.Server.Addr = ":8081" // Different port from collector
+	cfg.ClickHouse.Addr = "localhost:9000"
+	cfg.ClickHouse.Database = "logs_db"
+	cfg.ClickHouse.Username = "default"
+	cfg.ClickHouse.Password = "password"
+	cfg.TLS.ReloadInterval = time.Minute
+	cfg.Health.Timeout = 2 * time.Second
+	cfg.CORS.AllowedOrigins = []string{"http://localhost:3000"}
+	cfg.Auth.CacheTTL = time.Minute
+	cfg.Auth.NegativeCacheTTL = 10 * time.Second
+	cfg.Auth.StaleTTL = time.Hour
+	cfg.Auth.Listen = true
+	cfg.Auth.AllowPlaintextKeys = true
+	cfg.Auth.UsageFlushInterval = 30 * time.Second
+	cfg.Export.MaxRows = 100000
+	cfg.Export.AdminMaxRows = 1000000
+	return cfg
+}
+
+func (c *Config) Validate() error {
+	var errs []error
+	if c.Server.Addr == "" {
+		errs = append(errs, errors.New("server.addr is required"))
+	}
+	if c.ClickHouse.Addr == "" {
+		errs = append(errs, errors.New("clickhouse.addr is required"))
+	}
+	if c.ClickHouse.Database == "" {
+		errs = append(errs, errors.New("clickhouse.database is required"))
+	}
+	if c.Postgres.URL == "" && c.Session.Secret == "" {
+		errs = append(errs, errors.New("postgres.url (DATABASE_URL) or session.secret (NEXTAUTH_SECRET) is required to authenticate readers"))
+	}
+	if c.Health.Timeout <= 0 {
+		errs = append(errs, errors.New("health.timeout must be positive"))
+	}
+	errs = append(errs, c.TLS.Validate()...)
+	errs = append(errs, c.Auth.Validate()...)
+	errs = append(errs, c.Export.Validate()...)
+	return errors.Join(errs...)
+}
@@ -2,74 +2,232 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/davidojo1144/LogStream/shared/auth"
+	"github.com/davidojo1144/LogStream/shared/logquery"
+	"github.com/davidojo1144/LogStream/shared/logs"
+	"github.com/davidojo1144/LogStream/shared/metrics"
+	"github.com/davidojo1144/LogStream/shared/serve"
 	"github.com/gorilla/websocket"
 )
 
 type LogHandler struct {
 	repo     *LogRepository
+	auth     *auth.ReadAuth
+	export   logquery.ExportConfig
+	searches *logquery.SavedSearches // nil without a Postgres database
 	upgrader websocket.Upgrader
 }
 
-func NewLogHandler(repo *LogRepository) *LogHandler {
+func NewLogHandler(repo *LogRepository, auth *auth.ReadAuth, cors *serve.CORS, export logquery.ExportConfig, searches *logquery.SavedSearches) *LogHandler {
 	return &LogHandler{
-		repo: repo,
+		repo:     repo,
+		auth:     auth,
+		export:   export,
+		searches: searches,
 		upgrader: websocket.Upgrader{
 			ReadBufferSize:  1024,
 			WriteBufferSize: 1024,
-			CheckOrigin: func(r *http.Request) bool {
-				return true // Allow all origins for demo
-			},
+			CheckOrigin:     cors.CheckOrigin,
 		},
 	}
 }
 
 func (h *LogHandler) GetLogs(w http.ResponseWriter, r *http.Request) {
-	// Enable CORS for frontend
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-
-	if r.Method == http.MethodOptions {
+	key, ok := h.auth.Authorize(w, r)
+	if !ok {
 		return
 	}
 
-	q := h.parseQuery(r)
-	logs, err := h.repo.GetLogs(r.Context(), q)
+	q, err := h.parseQuery(r, key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page, err := h.repo.GetLogs(r.Context(), q)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	logquery.Highlight(page.Logs, q.Filter)
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(logs)
+	json.NewEncoder(w).Encode(page)
 }
 
 func (h *LogHandler) GetStats(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	key, ok := h.auth.Authorize(w, r)
+	if !ok {
+		return
+	}
+
+	q, err := h.parseQuery(r, key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p, err := logquery.ParseStatsParams(r.URL.Query(), q.StartTime, q.EndTime)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	stats, err := h.repo.GetStats(r.Context(), q, p)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p.Response(stats))
+}
+
+func (h *LogHandler) GetFacets(w http.ResponseWriter, r *http.Request) {
+	key, ok := h.auth.Authorize(w, r)
+	if !ok {
+		return
+	}
+
+	q, err := h.parseQuery(r, key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p, err := logquery.ParseFacetParams(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	facets, err := h.repo.GetFacets(r.Context(), q, p)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(facets)
+}
+
+func (h *LogHandler) GetAggregate(w http.ResponseWriter, r *http.Request) {
+	key, ok := h.auth.Authorize(w, r)
+	if !ok {
+		return
+	}
+
+	q, err := h.parseQuery(r, key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p, err := logquery.ParseAggregateParams(r.URL.Query(), q.StartTime, q.EndTime)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	res, err := h.repo.GetAggregate(r.Context(), q, p)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func (h *LogHandler) GetLog(w http.ResponseWriter, r *http.Request) {
+	key, ok := h.auth.Authorize(w, r)
+	if !ok {
+		return
+	}
+
+	var q LogQuery
+	if key != nil {
+		q.AllowedServices, q.AllowedLevels = key.Services, key.Severities()
+	}
+
+	l, err := h.repo.GetLog(r.Context(), r.PathValue("id"), q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if l == nil {
+		http.Error(w, "log not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(l)
+}
+
+func (h *LogHandler) GetLogContext(w http.ResponseWriter, r *http.Request) {
+	key, ok := h.auth.Authorize(w, r)
+	if !ok {
+		return
+	}
 
-	if r.Method == http.MethodOptions {
+	p, err := logquery.ParseContextParams(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	var q LogQuery
+	if key != nil {
+		q.AllowedServices, q.AllowedLevels = key.Services, key.Severities()
+	}
 
-	q := h.parseQuery(r)
-	stats, err := h.repo.GetStats(r.Context(), q)
+	lc, err := h.repo.GetContext(r.Context(), r.PathValue("id"), q, p)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if lc == nil {
+		http.Error(w, "log not found", http.StatusNotFound)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(stats)
+	json.NewEncoder(w).Encode(lc)
+}
+
+func (h *LogHandler) Export(w http.ResponseWriter, r *http.Request) {
+	key, ok := h.auth.Authorize(w, r)
+	if !ok {
+		return
+	}
+
+	q, err := h.parseQuery(r, key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	format, err := logquery.ParseExportFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// One row past the cap tells whether the export was truncated
+	limit := h.export.RowCap(key)
+	err = logquery.Export(w, format, limit, func(write func(logs.Entry) error) error {
+		return h.repo.Export(r.Context(), q, limit+1, write)
+	})
+	if err != nil {
+		// The status is already sent, so the client sees a cut-off body
+		log.Printf("Export failed: %v", err)
+	}
 }
 
 func (h *LogHandler) WebSocketHandler(w http.ResponseWriter, r *http.Request) {
+	key, ok := h.auth.Authorize(w, r)
+	if !ok {
+		return
+	}
+
 	conn, err := h.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println("Failed to upgrade to WebSocket:", err)
@@ -77,6 +235,9 @@ func (h *LogHandler) WebSocketHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer conn.Close()
 
+	metrics.WebsocketClients.Inc()
+	defer metrics.WebsocketClients.Dec()
+
 	// Simple streaming loop: check for new logs every 2 seconds
 	// In production, this should be event-driven (e.g. consuming Kafka)
 	// or use efficient polling with cursor.
@@ -94,13 +255,17 @@ func (h *LogHandler) WebSocketHandler(w http.ResponseWriter, r *http.Request) {
 				EndTime:   time.Now(),
 				Limit:     100,
 			}
+			if key != nil {
+				q.AllowedServices, q.AllowedLevels = key.Services, key.Severities()
+			}
 			
-			logs, err := h.repo.GetLogs(r.Context(), q)
+			page, err := h.repo.GetLogs(r.Context(), q)
 			if err != nil {
 				log.Println("Error fetching logs for WS:", err)
 				continue
 			}
 
+			logs := page.Logs
 			if len(logs) > 0 {
 				lastTimestamp = logs[0].Timestamp.Add(1 * time.Nanosecond) // Advance cursor
 				
@@ -114,8 +279,11 @@ func (h *LogHandler) WebSocketHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (h *LogHandler) parseQuery(r *http.Request) LogQuery {
-	query := r.URL.Query()
+func (h *LogHandler) parseQuery(r *http.Request, key *auth.ApiKey) (LogQuery, error) {
+	query, err := h.searches.Expand(r.Context(), r.URL.Query())
+	if err != nil {
+		return LogQuery{}, err
+	}
 	
 	endTime := time.Now()
 	startTime := endTime.Add(-1 * time.Hour)
@@ -132,17 +300,56 @@ func (h *LogHandler) parseQuery(r *http.Request) LogQuery {
 		}
 	}
 	if l := query.Get("limit"); l != "" {
-		if parsed, err := strconv.Atoi(l); err == nil {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 {
 			limit = parsed
 		}
 	}
 
-	return LogQuery{
+	filter, err := logquery.ParseQuery(query.Get("q"))
+	if err != nil {
+		return LogQuery{}, err
+	}
+	metadata, err := logquery.MetadataFilter(query)
+	if err != nil {
+		return LogQuery{}, err
+	}
+
+	cursor, err := logquery.ParseCursor(query.Get("cursor"))
+	if err != nil {
+		return LogQuery{}, err
+	}
+
+	var levels []int
+	if l := query.Get("level"); l != "" {
+		for _, name := range strings.Split(l, ",") {
+			severity, ok := logs.ParseSeverity(name)
+			if !ok {
+				return LogQuery{}, fmt.Errorf("unknown level %q", name)
+			}
+			levels = append(levels, severity)
+		}
+	}
+	var minLevel int
+	if l := query.Get("min_level"); l != "" {
+		severity, ok := logs.ParseSeverity(l)
+		if !ok {
+			return LogQuery{}, fmt.Errorf("unknown level %q", l)
+		}
+		minLevel = severity
+	}
+
+	q := LogQuery{
 		Service:   query.Get("service"),
-		Level:     query.Get("level"),
-		Search:    query.Get("search"),
+		Levels:    levels,
+		MinLevel:  minLevel,
 		StartTime: startTime,
 		EndTime:   endTime,
 		Limit:     limit,
+		Filter:    logquery.AndQuery(logquery.AndQuery(filter, metadata), logquery.SearchQuery(query.Get("search"))),
+		Cursor:    cursor,
+	}
+	if key != nil {
+		q.AllowedServices, q.AllowedLevels = key.Services, key.Severities()
 	}
+	return q, nil
 }
@@ -8,36 +8,92 @@ import (
 	"os/signal"
 	"syscall"
 	"time"
+
+	"github.com/davidojo1144/LogStream/shared/auth"
+	"github.com/davidojo1144/LogStream/shared/config"
+	"github.com/davidojo1144/LogStream/shared/health"
+	"github.com/davidojo1144/LogStream/shared/ingest"
+	"github.com/davidojo1144/LogStream/shared/logquery"
+	"github.com/davidojo1144/LogStream/shared/metrics"
+	"github.com/davidojo1144/LogStream/shared/serve"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func main() {
 	log.Println("Starting Log Stream API...")
 
 	// Configuration
-	clickhouseAddr := "localhost:9000"
-	serverAddr := ":8081" // Different port from collector
+	cfg := defaultConfig()
+	if err := config.Load(cfg, "api", os.Args[1:]); err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
 
 	// Initialize Repository
-	repo, err := NewLogRepository(clickhouseAddr)
+	repo, err := NewLogRepository(cfg)
 	if err != nil {
 		log.Fatalf("Failed to initialize repository: %v", err)
 	}
 
+	// Initialize Auth Validator
+	var validator *auth.Validator
+	if cfg.Postgres.URL != "" {
+		validator, err = auth.NewValidator(cfg.Postgres.URL, cfg.Auth)
+		if err != nil {
+			log.Fatalf("Failed to initialize API key validator: %v", err)
+		}
+		defer validator.Close()
+	}
+	var sessions *auth.SessionVerifier
+	if cfg.Session.Secret != "" {
+		sessions = auth.NewSessionVerifier(cfg.Session.Secret)
+	}
+
 	// Initialize Handler
-	handler := NewLogHandler(repo)
+	readAuth := auth.NewReadAuth(validator, sessions)
+	cors := serve.NewCORS(cfg.CORS.AllowedOrigins)
+	var searches *logquery.SavedSearches
+	if validator != nil {
+		// Saved searches live in the API key database
+		searches = logquery.NewSavedSearches(validator.DB(), readAuth)
+	}
+	handler := NewLogHandler(repo, readAuth, cors, cfg.Export, searches)
 
 	// Setup Router
 	mux := http.NewServeMux()
-	mux.HandleFunc("/logs", handler.GetLogs)
-	mux.HandleFunc("/stats", handler.GetStats)
-	mux.HandleFunc("/ws", handler.WebSocketHandler)
+	mux.HandleFunc("/logs", metrics.Instrument("/logs", cors.Wrap(handler.GetLogs)))
+	mux.HandleFunc("/logs/{id}", metrics.Instrument("/logs/id", cors.Wrap(handler.GetLog)))
+	mux.HandleFunc("/logs/{id}/context", metrics.Instrument("/logs/context", cors.Wrap(handler.GetLogContext)))
+	mux.HandleFunc("/stats", metrics.Instrument("/stats", cors.Wrap(handler.GetStats)))
+	mux.HandleFunc("/facets", metrics.Instrument("/facets", cors.Wrap(handler.GetFacets)))
+	mux.HandleFunc("/aggregate", metrics.Instrument("/aggregate", cors.Wrap(handler.GetAggregate)))
+	mux.HandleFunc("/export", metrics.Instrument("/export", cors.Wrap(handler.Export)))
+	if validator != nil {
+		// Usage is metered into the API key database
+		usage := ingest.NewUsageHandler(validator.DB(), readAuth)
+		mux.HandleFunc("/usage", metrics.Instrument("/usage", cors.Wrap(usage.ServeHTTP)))
+		mux.HandleFunc("/searches", metrics.Instrument("/searches", cors.Wrap(searches.ServeHTTP)))
+		mux.HandleFunc("/searches/{id}", metrics.Instrument("/searches/id", cors.Wrap(searches.ServeHTTP)))
+	}
+	mux.HandleFunc("/ws", metrics.Instrument("/ws", handler.WebSocketHandler))
+	mux.Handle("/metrics", promhttp.Handler())
+
+	checks := []health.Check{{Name: "clickhouse", Critical: true, Check: repo.Ping}}
+	if validator != nil {
+		checks = append(checks, health.Check{Name: "postgres", Critical: true, Check: validator.Ping})
+	}
+	probes := health.New(cfg.Health.Timeout, checks...)
+	mux.HandleFunc("/livez", probes.Livez)
+	mux.HandleFunc("/readyz", probes.Readyz)
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("OK"))
 	})
 
 	server := &http.Server{
-		Addr:    serverAddr,
+		Addr:    cfg.Server.Addr,
 		Handler: mux,
 	}
 
@@ -46,8 +102,8 @@ func main() {
 	signal.Notify(done, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 
 	go func() {
-		log.Printf("Listening on %s", serverAddr)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Printf("Listening on %s", cfg.Server.Addr)
+		if err := serve.ListenAndServe(server, cfg.TLS); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Server failed: %v", err)
 		}
 	}()
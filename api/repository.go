@@ -3,21 +3,27 @@ package main
 import (
 	"context"
 	"fmt"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/davidojo1144/LogStream/shared/logquery"
+	"github.com/davidojo1144/LogStream/shared/logs"
+	"github.com/davidojo1144/LogStream/shared/metrics"
 )
 
 type LogRepository struct {
 	conn clickhouse.Conn
 }
 
-func NewLogRepository(addr string) (*LogRepository, error) {
+func NewLogRepository(cfg *Config) (*LogRepository, error) {
 	conn, err := clickhouse.Open(&clickhouse.Options{
-		Addr: []string{addr},
+		Addr: []string{cfg.ClickHouse.Addr},
 		Auth: clickhouse.Auth{
-			Database: "logs_db",
-			Username: "default",
-			Password: "password",
+			Database: cfg.ClickHouse.Database,
+			Username: cfg.ClickHouse.Username,
+			Password: cfg.ClickHouse.Password,
 		},
 	})
 	if err != nil {
@@ -31,8 +37,19 @@ func NewLogRepository(addr string) (*LogRepository, error) {
 	return &LogRepository{conn: conn}, nil
 }
 
-func (r *LogRepository) GetLogs(ctx context.Context, q LogQuery) ([]LogEntry, error) {
-	finalQuery := `SELECT timestamp, service, level, message, metadata FROM logs_db.logs WHERE timestamp >= ? AND timestamp <= ?`
+func (r *LogRepository) Ping(ctx context.Context) error {
+	return r.conn.Ping(ctx)
+}
+
+// logTiebreak orders entries with the same timestamp by their ULID. Rows
+// stored before IDs were assigned at ingest have an empty id; they fall back
+// to a hash of the fields the consumer deduplicates on.
+const logTiebreak = "if(id != '', id, toString(cityHash64(service, level, message)))"
+
+func (r *LogRepository) GetLogs(ctx context.Context, q LogQuery) (page logquery.LogPage, err error) {
+	defer metrics.ObserveQuery("logs", time.Now(), &err)
+
+	finalQuery := logColumns + ` WHERE timestamp >= ? AND timestamp <= ?`
 	queryArgs := []interface{}{q.StartTime, q.EndTime}
 
 	if q.Service != "" {
@@ -40,67 +57,407 @@ func (r *LogRepository) GetLogs(ctx context.Context, q LogQuery) ([]LogEntry, er
 		queryArgs = append(queryArgs, q.Service)
 	}
 
-	if q.Level != "" {
-		finalQuery += " AND level = ?"
-		queryArgs = append(queryArgs, q.Level)
+	finalQuery, queryArgs = filterLevels(finalQuery, queryArgs, q)
+	finalQuery, queryArgs = filter(finalQuery, queryArgs, q)
+	finalQuery, queryArgs = restrict(finalQuery, queryArgs, q)
+
+	order := "DESC"
+	if c := q.Cursor; c != nil {
+		if c.Direction == logquery.CursorPrev {
+			finalQuery += " AND (timestamp, " + logTiebreak + ") > (?, ?)"
+			order = "ASC"
+		} else {
+			finalQuery += " AND (timestamp, " + logTiebreak + ") < (?, ?)"
+		}
+		queryArgs = append(queryArgs, c.Timestamp, c.ID)
 	}
 
-	if q.Search != "" {
-		finalQuery += " AND message ILIKE ?"
-		queryArgs = append(queryArgs, "%"+q.Search+"%")
+	finalQuery += " ORDER BY timestamp " + order + ", " + logTiebreak + " " + order + " LIMIT ?"
+	queryArgs = append(queryArgs, q.Limit+1)
+
+	entries, ids, err := r.queryLogs(ctx, finalQuery, queryArgs...)
+	if err != nil {
+		return logquery.LogPage{}, err
 	}
 
-	finalQuery += " ORDER BY timestamp DESC LIMIT ?"
-	queryArgs = append(queryArgs, q.Limit)
+	return logquery.NewLogPage(entries, ids, q.Limit, q.Cursor), nil
+}
+
+// logColumns selects what queryLogs scans.
+const logColumns = `SELECT id, timestamp, service, raw_level, severity, message, metadata, ` + logTiebreak + ` FROM logs_db.logs`
 
-	rows, err := r.conn.Query(ctx, finalQuery, queryArgs...)
+// queryLogs runs a query selecting logColumns and returns the entries with
+// their tiebreaks.
+func (r *LogRepository) queryLogs(ctx context.Context, query string, args ...interface{}) ([]logs.Entry, []string, error) {
+	var entries []logs.Entry
+	var ids []string
+	err := r.eachLog(ctx, query, args, func(l logs.Entry, tiebreak string) error {
+		entries = append(entries, l)
+		ids = append(ids, tiebreak)
+		return nil
+	})
+	return entries, ids, err
+}
+
+// eachLog runs a query selecting logColumns and calls fn for each entry as
+// it is read, stopping at the first error fn returns.
+func (r *LogRepository) eachLog(ctx context.Context, query string, args []interface{}, fn func(l logs.Entry, tiebreak string) error) error {
+	rows, err := r.conn.Query(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer rows.Close()
 
-	var logs []LogEntry
 	for rows.Next() {
-		var l LogEntry
-		if err := rows.Scan(&l.Timestamp, &l.Service, &l.Level, &l.Message, &l.Metadata); err != nil {
-			return nil, err
+		var l logs.Entry
+		var severity uint8
+		var tiebreak string
+		if err := rows.Scan(&l.ID, &l.Timestamp, &l.Service, &l.RawLevel, &severity, &l.Message, &l.Metadata, &tiebreak); err != nil {
+			return err
+		}
+		// Rows written before normalization keep their raw level in the
+		// level column, so name the level from the severity.
+		l.Severity = int(severity)
+		l.Level = logs.LevelNames[l.Severity]
+		if err := fn(l, tiebreak); err != nil {
+			return err
 		}
-		logs = append(logs, l)
 	}
+	return rows.Err()
+}
+
+// Export calls fn for each entry matching q, oldest first, up to limit of
+// them. ClickHouse sends results in blocks as it reads them, so memory does
+// not grow with the number of rows.
+func (r *LogRepository) Export(ctx context.Context, q LogQuery, limit int64, fn func(logs.Entry) error) (err error) {
+	defer metrics.ObserveQuery("export", time.Now(), &err)
+
+	query := logColumns + ` WHERE timestamp >= ? AND timestamp <= ?`
+	args := []interface{}{q.StartTime, q.EndTime}
 
-	return logs, nil
+	if q.Service != "" {
+		query += " AND service = ?"
+		args = append(args, q.Service)
+	}
+
+	query, args = filterLevels(query, args, q)
+	query, args = filter(query, args, q)
+	query, args = restrict(query, args, q)
+
+	query += " ORDER BY timestamp ASC, " + logTiebreak + " ASC LIMIT ?"
+	args = append(args, limit)
+
+	return r.eachLog(ctx, query, args, func(l logs.Entry, tiebreak string) error {
+		if l.ID == "" {
+			l.ID = logquery.LogID(l.Timestamp, tiebreak)
+		}
+		return fn(l)
+	})
 }
 
-func (r *LogRepository) GetStats(ctx context.Context, q LogQuery) ([]LogStats, error) {
-	// Aggregate logs per minute
+// GetLog returns the entry with the given ID, or nil when there is none the
+// caller may read. Only q's restrictions apply.
+func (r *LogRepository) GetLog(ctx context.Context, id string, q LogQuery) (l *logs.Entry, err error) {
+	defer metrics.ObserveQuery("log", time.Now(), &err)
+
+	l, _, err = r.findLog(ctx, id, q)
+	return l, err
+}
+
+// findLog looks an entry up by its ULID, within the millisecond the ULID
+// holds, or by the LogID of an entry stored without one. It also returns
+// the entry's tiebreak.
+func (r *LogRepository) findLog(ctx context.Context, id string, q LogQuery) (*logs.Entry, string, error) {
+	var query string
+	var args []interface{}
+	if t, ok := logs.IDTime(id); ok {
+		query, args = logColumns+" WHERE id = ? AND timestamp >= ? AND timestamp < ?", []interface{}{id, t, t.Add(time.Millisecond)}
+	} else {
+		ref, err := logquery.ParseLogID(id)
+		if err != nil {
+			return nil, "", nil
+		}
+		query, args = logColumns+" WHERE id = '' AND timestamp = ? AND "+logTiebreak+" = ?", []interface{}{ref.Timestamp, ref.ID}
+	}
+
+	query, args = restrict(query, args, q)
+	entries, ids, err := r.queryLogs(ctx, query+" LIMIT 1", args...)
+	if err != nil || len(entries) == 0 {
+		return nil, "", err
+	}
+	l := entries[0]
+	if l.ID == "" {
+		l.ID = logquery.LogID(l.Timestamp, ids[0])
+	}
+	return &l, ids[0], nil
+}
+
+// GetContext returns the entry with the given ID and the entries before and
+// after it that match p, or nil when there is no such entry or the caller
+// may not read it. Only q's restrictions apply.
+func (r *LogRepository) GetContext(ctx context.Context, id string, q LogQuery, p logquery.ContextParams) (lc *logquery.LogContext, err error) {
+	defer metrics.ObserveQuery("context", time.Now(), &err)
+
+	pivot, tiebreak, err := r.findLog(ctx, id, q)
+	if err != nil || pivot == nil {
+		return nil, err
+	}
+	lc = &logquery.LogContext{Log: *pivot}
+
+	q.Filter = p.Filter(lc.Log)
+	side := func(cmp, order string, limit int) ([]logs.Entry, error) {
+		return logquery.ContextSide(limit, func(window time.Duration) ([]logs.Entry, error) {
+			bound, edge := ">=", pivot.Timestamp.Add(-window)
+			if cmp == ">" {
+				bound, edge = "<=", pivot.Timestamp.Add(window)
+			}
+			query, args := restrict(logColumns+" WHERE (timestamp, "+logTiebreak+") "+cmp+" (?, ?) AND timestamp "+bound+" ?", []interface{}{pivot.Timestamp, tiebreak, edge}, q)
+			query, args = filter(query, args, q)
+			query += " ORDER BY timestamp " + order + ", " + logTiebreak + " " + order + " LIMIT ?"
+			found, ids, err := r.queryLogs(ctx, query, append(args, limit)...)
+			if err != nil {
+				return nil, err
+			}
+			entries := make([]logs.Entry, len(found))
+			for i, l := range found {
+				entries[i] = l
+				if l.ID == "" {
+					entries[i].ID = logquery.LogID(l.Timestamp, ids[i])
+				}
+			}
+			return entries, nil
+		})
+	}
+
+	if lc.Before, err = side("<", "DESC", p.Before); err != nil {
+		return nil, err
+	}
+	slices.Reverse(lc.Before)
+	if lc.After, err = side(">", "ASC", p.After); err != nil {
+		return nil, err
+	}
+	return lc, nil
+}
+
+func (r *LogRepository) GetStats(ctx context.Context, q LogQuery, p logquery.StatsParams) (stats logquery.StatsResult, err error) {
+	defer metrics.ObserveQuery("stats", time.Now(), &err)
+
+	// Count logs per bucket and group; BuildStats fills the empty buckets
+	cols, args := p.Columns(logquery.ClickHouse, 0)
 	query := `
-		SELECT toStartOfMinute(timestamp) as time_bucket, count() as count
+		SELECT ` + cols + `, count() as count
 		FROM logs_db.logs
 		WHERE timestamp >= ? AND timestamp <= ?
 	`
-	args := []interface{}{q.StartTime, q.EndTime}
+	args = append(args, q.StartTime, q.EndTime)
 
 	if q.Service != "" {
 		query += " AND service = ?"
 		args = append(args, q.Service)
 	}
 
-	query += " GROUP BY time_bucket ORDER BY time_bucket ASC"
+	query, args = filterLevels(query, args, q)
+	query, args = filter(query, args, q)
+	query, args = restrict(query, args, q)
+
+	query += " GROUP BY bucket, grp ORDER BY bucket ASC"
 
 	rows, err := r.conn.Query(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return logquery.StatsResult{}, err
+	}
+	defer rows.Close()
+
+	var counts []logquery.StatsRow
+	for rows.Next() {
+		var c logquery.StatsRow
+		if err := rows.Scan(&c.Bucket, &c.Group, &c.Count); err != nil {
+			return logquery.StatsResult{}, err
+		}
+		counts = append(counts, c)
+	}
+	if err := rows.Err(); err != nil {
+		return logquery.StatsResult{}, err
+	}
+
+	return p.BuildStats(counts), nil
+}
+
+// GetAggregate summarizes a numeric metadata field per bucket and group,
+// and counts its histogram in a second query once the range of values is
+// known.
+func (r *LogRepository) GetAggregate(ctx context.Context, q LogQuery, p logquery.AggregateParams) (res logquery.AggregateResult, err error) {
+	defer metrics.ObserveQuery("aggregate", time.Now(), &err)
+
+	where := " WHERE timestamp >= ? AND timestamp <= ?"
+	whereArgs := []interface{}{q.StartTime, q.EndTime}
+	if q.Service != "" {
+		where += " AND service = ?"
+		whereArgs = append(whereArgs, q.Service)
+	}
+	cond, condArgs := p.Condition(logquery.ClickHouse, 0)
+	where += " AND " + cond
+	whereArgs = append(whereArgs, condArgs...)
+	where, whereArgs = filterLevels(where, whereArgs, q)
+	where, whereArgs = filter(where, whereArgs, q)
+	where, whereArgs = restrict(where, whereArgs, q)
+
+	cols, args := p.Columns(logquery.ClickHouse, 0)
+	query := "SELECT " + cols + " FROM logs_db.logs" + where + " GROUP BY bucket, grp ORDER BY bucket ASC"
+
+	rows, err := r.conn.Query(ctx, query, append(args, whereArgs...)...)
+	if err != nil {
+		return logquery.AggregateResult{}, err
 	}
 	defer rows.Close()
 
-	var stats []LogStats
+	var aggs []logquery.AggregateRow
 	for rows.Next() {
-		var s LogStats
-		if err := rows.Scan(&s.Timestamp, &s.Count); err != nil {
-			return nil, err
+		var a logquery.AggregateRow
+		if err := rows.Scan(a.Dest(logquery.ClickHouse, len(p.Quantiles))...); err != nil {
+			return logquery.AggregateResult{}, err
+		}
+		aggs = append(aggs, a)
+	}
+	if err := rows.Err(); err != nil {
+		return logquery.AggregateResult{}, err
+	}
+	res = p.BuildAggregate(aggs)
+
+	lo, hi, ok := logquery.AggregateRange(aggs)
+	if p.Bins == 0 || !ok {
+		return res, nil
+	}
+	bin, args := p.HistogramColumn(logquery.ClickHouse, 0, lo, hi)
+	query = "SELECT " + bin + " AS bin, count() FROM logs_db.logs" + where + " GROUP BY bin"
+
+	binRows, err := r.conn.Query(ctx, query, append(args, whereArgs...)...)
+	if err != nil {
+		return logquery.AggregateResult{}, err
+	}
+	defer binRows.Close()
+
+	counts := make(map[int64]uint64)
+	for binRows.Next() {
+		var n int64
+		var count uint64
+		if err := binRows.Scan(&n, &count); err != nil {
+			return logquery.AggregateResult{}, err
 		}
-		stats = append(stats, s)
+		counts[n] = count
 	}
+	if err := binRows.Err(); err != nil {
+		return logquery.AggregateResult{}, err
+	}
+	p.BuildHistogram(&res, lo, hi, counts)
+	return res, nil
+}
 
-	return stats, nil
+// GetFacets finds the most common values of each field in one pass, with
+// topK in its counts mode and uniq.
+func (r *LogRepository) GetFacets(ctx context.Context, q LogQuery, p logquery.FacetParams) (facets []logquery.Facet, err error) {
+	defer metrics.ObserveQuery("facets", time.Now(), &err)
+
+	query, args := facetsQuery(q, p)
+	values := make([][]string, len(p.Fields))
+	counts := make([][]uint64, len(p.Fields))
+	facets = make([]logquery.Facet, len(p.Fields))
+	var dest []interface{}
+	for i, field := range p.Fields {
+		facets[i].Field = field
+		dest = append(dest, &values[i], &counts[i], &facets[i].Cardinality, &facets[i].Count)
+	}
+	if err := r.conn.QueryRow(ctx, query, args...).Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	for i, field := range p.Fields {
+		facets[i].Values = make([]logquery.FacetValue, len(values[i]))
+		for j, v := range values[i] {
+			facets[i].Values[j] = logquery.FacetValue{Value: logquery.FacetLabel(field, v), Count: counts[i][j]}
+		}
+	}
+	return facets, nil
+}
+
+// facetsQuery selects, for each field, its top values and their counts, its
+// cardinality and how many logs have it.
+func facetsQuery(q LogQuery, p logquery.FacetParams) (string, []interface{}) {
+	var cols []string
+	var args []interface{}
+	for i, field := range p.Fields {
+		value, has, fieldArgs := logquery.FacetColumn(logquery.ClickHouse, 0, field)
+		args = append(args, fieldArgs...)
+		top := fmt.Sprintf("topK(%d, 3, 'counts')(%s)", p.Top, value)
+		uniq, count := "uniq("+value+")", "count()"
+		if has != "" {
+			// Placeholders are bound in order, once each, so the metadata
+			// expressions are named where they first appear and reused.
+			top = fmt.Sprintf("topKIf(%d, 3, 'counts')(%s AS value%d, %s AS has%d)", p.Top, value, i, has, i)
+			uniq, count = fmt.Sprintf("uniqIf(value%d, has%d)", i, i), fmt.Sprintf("countIf(has%d)", i)
+		}
+		cols = append(cols, fmt.Sprintf("arrayMap(t -> t.1, %s AS top%d), arrayMap(t -> t.2, top%d), %s, %s", top, i, i, uniq, count))
+	}
+
+	query := `
+		SELECT ` + strings.Join(cols, ", ") + `
+		FROM logs_db.logs
+		WHERE timestamp >= ? AND timestamp <= ?
+	`
+	args = append(args, q.StartTime, q.EndTime)
+
+	if q.Service != "" {
+		query += " AND service = ?"
+		args = append(args, q.Service)
+	}
+
+	query, args = filterLevels(query, args, q)
+	query, args = filter(query, args, q)
+	query, args = restrict(query, args, q)
+	return query, args
+}
+
+// filterLevels adds the level and min_level parameters.
+func filterLevels(query string, args []interface{}, q LogQuery) (string, []interface{}) {
+	if len(q.Levels) > 0 {
+		query += " AND has(?, severity)"
+		args = append(args, severityList(q.Levels))
+	}
+	if q.MinLevel > 0 {
+		query += " AND severity >= ?"
+		args = append(args, q.MinLevel)
+	}
+	return query, args
+}
+
+// severityList converts severities to the UInt8 element type of the column
+// so has() can compare them.
+func severityList(severities []int) []uint8 {
+	list := make([]uint8, len(severities))
+	for i, s := range severities {
+		list[i] = uint8(s)
+	}
+	return list
+}
+
+// filter adds the conditions of the q search query.
+func filter(query string, args []interface{}, q LogQuery) (string, []interface{}) {
+	if q.Filter == nil {
+		return query, args
+	}
+	cond, condArgs := logquery.CompileQuery(q.Filter, logquery.ClickHouse, 0)
+	return query + " AND " + cond, append(args, condArgs...)
+}
+
+// restrict limits a query to the services and levels the caller may read.
+func restrict(query string, args []interface{}, q LogQuery) (string, []interface{}) {
+	if len(q.AllowedServices) > 0 {
+		query += " AND has(?, service)"
+		args = append(args, q.AllowedServices)
+	}
+	if len(q.AllowedLevels) > 0 {
+		query += " AND has(?, severity)"
+		args = append(args, severityList(q.AllowedLevels))
+	}
+	return query, args
 }
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/davidojo1144/LogStream/shared/logquery"
+)
+
+func TestFacetsQueryBindsEveryPlaceholder(t *testing.T) {
+	filter, err := logquery.ParseQuery("metadata.env:prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	q := LogQuery{
+		Service:         "checkout",
+		StartTime:       start,
+		EndTime:         start.Add(time.Hour),
+		Filter:          filter,
+		AllowedServices: []string{"checkout"},
+	}
+	p := logquery.FacetParams{Fields: []string{"service", "metadata.region", "level", "metadata.pod"}, Top: 5}
+
+	query, args := facetsQuery(q, p)
+	// clickhouse-go binds ? in order, one argument each.
+	if n := strings.Count(query, "?"); n != len(args) {
+		t.Fatalf("query has %d placeholders for %d arguments:\n%s\n%v", n, len(args), query, args)
+	}
+	want := []interface{}{"region", "region", "pod", "pod", start, start.Add(time.Hour), "checkout", "env", "prod"}
+	for i, w := range want {
+		if args[i] != w {
+			t.Errorf("argument %d = %v, want %v", i, args[i], w)
+		}
+	}
+	for _, ref := range []string{"uniqIf(value1, has1)", "countIf(has1)", "uniqIf(value3, has3)", "uniq(service)"} {
+		if !strings.Contains(query, ref) {
+			t.Errorf("query does not contain %s:\n%s", ref, query)
+		}
+	}
+}
@@ -2,26 +2,26 @@ package main
 
 import (
 	"time"
-)
 
-type LogEntry struct {
-	Timestamp   time.Time         `json:"timestamp"`
-	Service     string            `json:"service"`
-	Level       string            `json:"level"`
-	Message     string            `json:"message"`
-	Metadata    map[string]string `json:"metadata,omitempty"`
-}
+	"github.com/davidojo1144/LogStream/shared/logquery"
+)
 
 type LogQuery struct {
 	Service   string    `json:"service"`
-	Level     string    `json:"level"`
-	Search    string    `json:"search"`
+	Levels    []int     `json:"levels"`    // severities to match exactly
+	MinLevel  int       `json:"min_level"` // lowest severity to match
 	StartTime time.Time `json:"start_time"`
 	EndTime   time.Time `json:"end_time"`
 	Limit     int       `json:"limit"`
-}
 
-type LogStats struct {
-	Timestamp time.Time `json:"timestamp"`
-	Count     uint64    `json:"count"`
+	// Filter is the parsed q parameter; nil when absent.
+	Filter logquery.QueryExpr `json:"-"`
+
+	// Cursor continues from a previous page; nil for the newest entries.
+	Cursor *logquery.Cursor `json:"-"`
+
+	// AllowedServices and AllowedLevels come from the caller's API key
+	// restrictions; empty means unrestricted.
+	AllowedServices []string `json:"-"`
+	AllowedLevels   []int    `json:"-"` // severities
 }
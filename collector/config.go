@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/davidojo1144/LogStream/shared/auth"
+	"github.com/davidojo1144/LogStream/shared/ingest"
+	"github.com/davidojo1144/LogStream/shared/serve"
+)
+
+type Config struct {
+	Server struct {
+		Addr string `yaml:"addr" env:"SERVER_ADDR" flag:"addr" usage:"HTTP listen address"`
+	} `yaml:"server"`
+
+	Kafka struct {
+		Brokers []string `yaml:"brokers" env:"KAFKA_BROKERS" flag:"kafka-brokers" usage:"Comma-separated Kafka brokers"`
+		Topic   string   `yaml:"topic" env:"KAFKA_TOPIC" flag:"kafka-topic" usage:"Kafka topic logs are produced to"`
+	} `yaml:"kafka"`
+
+	TLS serve.TLSConfig `yaml:"tls"`
+
+	Ingest struct {
+		RequireClientCert bool `yaml:"require_client_cert" env:"INGEST_REQUIRE_CLIENT_CERT" flag:"ingest-require-client-cert" usage:"Reject /ingest requests without a verified client certificate"`
+	} `yaml:"ingest"`
+
+	Health struct {
+		Timeout time.Duration `yaml:"timeout" env:"HEALTH_TIMEOUT" flag:"health-timeout" usage:"Per-dependency timeout for /readyz checks"`
+	} `yaml:"health"`
+
+	Auth auth.Config `yaml:"auth"`
+
+	Limits ingest.LimitsConfig `yaml:"limits"`
+
+	Metering struct {
+		FlushInterval time.Duration `yaml:"flush_interval" env:"METERING_FLUSH_INTERVAL" flag:"metering-flush-interval" usage:"How often per-key ingest volume is added to Postgres"`
+	} `yaml:"metering"`
+
+	Postgres struct {
+		URL string `yaml:"url" env:"DATABASE_URL" flag:"database-url" usage:"Postgres connection string for API keys" secret:"true"`
+	} `yaml:"postgres"`
+}
+
+func defaultConfig() *Config {
+	cfg := &Config{}
+	cfg.Server.Addr = ":8080"
+	cfg.Kafka.Brokers = []string{"localhost:9092"}
+	cfg.Kafka.Topic = "logs"
+	cfg.TLS.ReloadInterval = time.Minute
+	cfg.Health.Timeout = 2 * time.Second
+	cfg.Auth.CacheTTL = time.Minute
+	cfg.Auth.NegativeCacheTTL = 10 * time.Second
+	cfg.Auth.StaleTTL = time.Hour
+	cfg.Auth.Listen = true
+	cfg.Auth.AllowPlaintextKeys = true
+	cfg.Auth.UsageFlushInterval = 30 * time.Second
+	cfg.Limits.EntriesPerSecond = 1000
+	cfg.Limits.BytesPerSecond = 10 << 20
+	cfg.Limits.Burst = 2 * time.Second
+	cfg.Limits.FlushInterval = 10 * time.Second
+	cfg.Metering.FlushInterval = time.Minute
+	return cfg
+}
+
+func (c *Config) Validate() error {
+	var errs []error
+	if c.Server.Addr == "" {
+		errs = append(errs, errors.New("server.addr is required"))
+	}
+	if len(c.Kafka.Brokers) == 0 {
+		errs = append(errs, errors.New("kafka.brokers is required"))
+	}
+	if c.Kafka.Topic == "" {
+		errs = append(errs, errors.New("kafka.topic is required"))
+	}
+	if c.Postgres.URL == "" {
+		errs = append(errs, errors.New("postgres.url is required (DATABASE_URL)"))
+	}
+	if c.Health.Timeout <= 0 {
+		errs = append(errs, errors.New("health.timeout must be positive"))
+	}
+	errs = append(errs, c.TLS.Validate()...)
+	errs = append(errs, c.Auth.Validate()...)
+	errs = append(errs, c.Limits.Validate()...)
+	if c.Metering.FlushInterval <= 0 {
+		errs = append(errs, errors.New("metering.flush_interval must be positive"))
+	}
+	if c.Ingest.RequireClientCert && c.TLS.ClientCAFile == "" {
+		errs = append(errs, errors.New("ingest.require_client_cert requires tls.client_ca_file"))
+	}
+	return errors.Join(errs...)
+}
@@ -2,20 +2,34 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"time"
+
+	"github.com/davidojo1144/LogStream/shared/auth"
+	"github.com/davidojo1144/LogStream/shared/ingest"
+	"github.com/davidojo1144/LogStream/shared/logs"
+	"github.com/davidojo1144/LogStream/shared/metrics"
+	"github.com/davidojo1144/LogStream/shared/serve"
 )
 
 type LogHandler struct {
-	producer  *KafkaProducer
-	validator *ApiKeyValidator
+	producer          *KafkaProducer
+	validator         *auth.Validator
+	limiter           *ingest.Limiter
+	meter             *ingest.Meter
+	requireClientCert bool
 }
 
-func NewLogHandler(producer *KafkaProducer, validator *ApiKeyValidator) *LogHandler {
+func NewLogHandler(producer *KafkaProducer, validator *auth.Validator, limiter *ingest.Limiter, meter *ingest.Meter, requireClientCert bool) *LogHandler {
 	return &LogHandler{
-		producer:  producer,
-		validator: validator,
+		producer:          producer,
+		validator:         validator,
+		limiter:           limiter,
+		meter:             meter,
+		requireClientCert: requireClientCert,
 	}
 }
 
@@ -25,35 +39,88 @@ func (h *LogHandler) HandleLog(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Validate API Key
-	apiKey := r.Header.Get("Authorization")
-	if apiKey == "" {
-		http.Error(w, "Missing Authorization header", http.StatusUnauthorized)
-		return
+	// A verified client certificate stands in for the API key
+	var key *auth.ApiKey
+	if subject := serve.ClientCertSubject(r); subject != "" {
+		if key = h.validator.ValidateCertSubject(subject); key == nil {
+			metrics.AuthFailures.WithLabelValues("unknown_certificate").Inc()
+			http.Error(w, "Client certificate is not linked to an active API Key", http.StatusUnauthorized)
+			return
+		}
+	} else {
+		if h.requireClientCert {
+			metrics.AuthFailures.WithLabelValues("missing_certificate").Inc()
+			http.Error(w, "Client certificate required", http.StatusUnauthorized)
+			return
+		}
+
+		// Validate API Key
+		apiKey := r.Header.Get("Authorization")
+		if apiKey == "" {
+			metrics.AuthFailures.WithLabelValues("missing_key").Inc()
+			http.Error(w, "Missing Authorization header", http.StatusUnauthorized)
+			return
+		}
+
+		if key = h.validator.Validate(apiKey); key == nil {
+			metrics.AuthFailures.WithLabelValues("invalid_key").Inc()
+			http.Error(w, "Invalid API Key", http.StatusUnauthorized)
+			return
+		}
 	}
 
-	if !h.validator.Validate(apiKey) {
-		http.Error(w, "Invalid API Key", http.StatusUnauthorized)
+	if !key.HasScope(auth.ScopeIngest) {
+		metrics.AuthFailures.WithLabelValues("missing_scope").Inc()
+		http.Error(w, "API Key does not have the ingest scope", http.StatusForbidden)
 		return
 	}
+	h.validator.Used(key, r)
 
-	var entry LogEntry
-	if err := json.NewDecoder(r.Body).Decode(&entry); err != nil {
+	body := &ingest.CountingReader{R: http.MaxBytesReader(w, r.Body, ingest.MaxBodyBytes)}
+	var entry logs.Entry
+	if err := json.NewDecoder(body).Decode(&entry); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("Request body exceeds %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+			return
+		}
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
+	// Only Normalize sets raw_level: one sent by the client would take
+	// priority over its level, bypassing the key's level restrictions.
+	entry.RawLevel = ""
+	entry.Normalize()
+
+	if !key.Allows(entry.Service, entry.Level) {
+		metrics.AuthFailures.WithLabelValues("restricted").Inc()
+		http.Error(w, fmt.Sprintf("API Key may not ingest %q logs for service %q", entry.Level, entry.Service), http.StatusForbidden)
+		return
+	}
+
+	decision := h.limiter.Allow(key, 1, body.N)
+	decision.SetHeaders(w)
+	if !decision.Allowed {
+		metrics.RateLimited.WithLabelValues(decision.Limit).Inc()
+		http.Error(w, decision.Reason, decision.Status)
+		return
+	}
 
 	// Set timestamp if missing
 	if entry.Timestamp.IsZero() {
 		entry.Timestamp = time.Now().UTC()
 	}
+	// IDs are always assigned here so they stay unique
+	entry.ID = logs.NewID(entry.Timestamp)
 
-	// Asynchronously push to Kafka
-	go func(e LogEntry) {
-		if err := h.producer.WriteLog(e); err != nil {
-			log.Printf("Error writing to Kafka: %v", err)
-		}
-	}(entry)
+	metrics.CountIngest(entry.Service, body.N)
+	h.meter.Record(key, entry.Service, 1, body.N)
+
+	// The writer is asynchronous, so this only queues the entry; delivery
+	// failures are counted by the producer.
+	if err := h.producer.WriteLog(entry); err != nil {
+		log.Printf("Error writing to Kafka: %v", err)
+	}
 
 	w.WriteHeader(http.StatusAccepted)
 	w.Write([]byte(`{"status":"accepted"}`))
@@ -0,0 +1,11 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var producerErrors = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "logstream_producer_errors_total",
+	Help: "Log entries that could not be written to Kafka.",
+})
@@ -4,9 +4,8 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"log"
-	"time"
 
+	"github.com/davidojo1144/LogStream/shared/logs"
 	_ "github.com/lib/pq"
 )
 
@@ -29,26 +28,24 @@ func NewPostgresProducer(connStr string) (*PostgresProducer, error) {
 	return &PostgresProducer{db: db}, nil
 }
 
-func (p *PostgresProducer) WriteLog(entry LogEntry) error {
+func (p *PostgresProducer) WriteLog(entry logs.Entry) error {
 	metadataJson, err := json.Marshal(entry.Metadata)
 	if err != nil {
 		return fmt.Errorf("failed to marshal metadata: %w", err)
 	}
 
 	query := `
-		INSERT INTO "Log" (id, timestamp, service, level, message, metadata, "createdAt")
-		VALUES ($1, $2, $3, $4, $5, $6, NOW())
+		INSERT INTO "Log" (id, timestamp, service, level, "rawLevel", severity, message, metadata, "createdAt")
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW())
 	`
 	
-	// Generate a CUID-like ID or UUID. For simplicity here we rely on DB default or generate one.
-	// Postgres CUID generation is tricky without extension, so we'll just let uuid-ossp or application generate it.
-	// Since Prisma expects a string ID, we should generate one. 
-	// For this Lite implementation, we'll use a simple timestamp-based ID if needed, 
-	// but better to just use a UUID.
-	
-	id := fmt.Sprintf("log_%d", time.Now().UnixNano())
+	// The handler assigns IDs at ingest; entries from elsewhere get one here.
+	id := entry.ID
+	if id == "" {
+		id = logs.NewID(entry.Timestamp)
+	}
 
-	_, err = p.db.Exec(query, id, entry.Timestamp, entry.Service, entry.Level, entry.Message, metadataJson)
+	_, err = p.db.Exec(query, id, entry.Timestamp, entry.Service, entry.Level, entry.RawLevel, entry.Severity, entry.Message, metadataJson)
 	if err != nil {
 		return fmt.Errorf("failed to insert log to postgres: %w", err)
 	}
@@ -4,8 +4,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log"
 	"time"
 
+	"github.com/davidojo1144/LogStream/shared/logs"
 	"github.com/segmentio/kafka-go"
 )
 
@@ -22,6 +24,14 @@ func NewKafkaProducer(brokers []string, topic string) *KafkaProducer {
 		BatchSize:  100,
 		BatchTimeout: 10 * time.Millisecond,
 		Async:      true,
+		// With Async, WriteMessages returns before delivery, so failed
+		// batches are only reported here.
+		Completion: func(messages []kafka.Message, err error) {
+			if err != nil {
+				producerErrors.Add(float64(len(messages)))
+				log.Printf("Error writing %d messages to Kafka: %v", len(messages), err)
+			}
+		},
 	}
 
 	return &KafkaProducer{
@@ -29,7 +39,7 @@ func NewKafkaProducer(brokers []string, topic string) *KafkaProducer {
 	}
 }
 
-func (p *KafkaProducer) WriteLog(entry LogEntry) error {
+func (p *KafkaProducer) WriteLog(entry logs.Entry) error {
 	value, err := json.Marshal(entry)
 	if err != nil {
 		return fmt.Errorf("failed to marshal log entry: %w", err)
@@ -47,12 +57,28 @@ func (p *KafkaProducer) WriteLog(entry LogEntry) error {
 	)
 
 	if err != nil {
+		producerErrors.Inc()
 		return fmt.Errorf("failed to write message to kafka: %w", err)
 	}
 
 	return nil
 }
 
+// Ping checks that the brokers answer and know the topic.
+func (p *KafkaProducer) Ping(ctx context.Context) error {
+	client := &kafka.Client{Addr: p.writer.Addr}
+	metadata, err := client.Metadata(ctx, &kafka.MetadataRequest{Topics: []string{p.writer.Topic}})
+	if err != nil {
+		return err
+	}
+	for _, t := range metadata.Topics {
+		if t.Error != nil {
+			return fmt.Errorf("topic %s: %w", t.Name, t.Error)
+		}
+	}
+	return nil
+}
+
 func (p *KafkaProducer) Close() error {
 	return p.writer.Close()
 }
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+type ClickHouseConfig struct {
+	Addr     string `yaml:"addr" env:"CLICKHOUSE_ADDR" flag:"clickhouse-addr" usage:"ClickHouse native protocol address"`
+	Database string `yaml:"database" env:"CLICKHOUSE_DATABASE" flag:"clickhouse-database" usage:"ClickHouse database"`
+	Username string `yaml:"username" env:"CLICKHOUSE_USERNAME" flag:"clickhouse-username" usage:"ClickHouse user"`
+	Password string `yaml:"password" env:"CLICKHOUSE_PASSWORD" usage:"ClickHouse password" secret:"true"`
+	Table    string `yaml:"table" env:"CLICKHOUSE_TABLE" flag:"table" usage:"ClickHouse table to insert into"`
+}
+
+func (c ClickHouseConfig) validate() []error {
+	var errs []error
+	if c.Addr == "" {
+		errs = append(errs, errors.New("clickhouse.addr is required"))
+	}
+	if c.Table == "" {
+		errs = append(errs, errors.New("clickhouse.table is required"))
+	}
+	return errs
+}
+
+type Config struct {
+	Kafka struct {
+		Brokers     []string `yaml:"brokers" env:"KAFKA_BROKERS" flag:"brokers" usage:"Comma-separated Kafka brokers"`
+		Topics      []string `yaml:"topics" env:"KAFKA_TOPICS" flag:"topics" usage:"Comma-separated Kafka topics to consume"`
+		GroupID     string   `yaml:"group_id" env:"KAFKA_GROUP_ID" flag:"group" usage:"Kafka consumer group ID"`
+		StartOffset string   `yaml:"start_offset" env:"KAFKA_START_OFFSET" flag:"start-offset" usage:"Where a new group starts: earliest, latest or an RFC3339 timestamp"`
+	} `yaml:"kafka"`
+
+	Consumer struct {
+		BatchSize       int           `yaml:"batch_size" env:"CONSUMER_BATCH_SIZE" flag:"batch-size" usage:"Maximum logs per ClickHouse insert"`
+		FlushInterval   time.Duration `yaml:"flush_interval" env:"CONSUMER_FLUSH_INTERVAL" flag:"flush-interval" usage:"Maximum time a partial batch waits before insert"`
+		ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" flag:"shutdown-timeout" usage:"Time allowed to flush the final batch on shutdown"`
+	} `yaml:"consumer"`
+
+	ClickHouse ClickHouseConfig `yaml:"clickhouse"`
+
+	Metrics struct {
+		Addr string `yaml:"addr" env:"METRICS_ADDR" flag:"metrics-addr" usage:"Listen address for /metrics, /livez and /readyz (empty disables it)"`
+	} `yaml:"metrics"`
+
+	Health struct {
+		Timeout time.Duration `yaml:"timeout" env:"HEALTH_TIMEOUT" flag:"health-timeout" usage:"Per-dependency timeout for /readyz checks"`
+	} `yaml:"health"`
+}
+
+func defaultClickHouseConfig() ClickHouseConfig {
+	return ClickHouseConfig{
+		Addr:     "localhost:9000",
+		Database: "logs_db",
+		Username: "default",
+		Password: "password",
+		Table:    "logs_db.logs",
+	}
+}
+
+func defaultConfig() *Config {
+	cfg := &Config{}
+	cfg.Kafka.Brokers = []string{"localhost:9092"}
+	cfg.Kafka.Topics = []string{"logs"}
+	cfg.Kafka.GroupID = "logstream-group"
+	cfg.Kafka.StartOffset = "earliest"
+	cfg.Consumer.BatchSize = 1000
+	cfg.Consumer.FlushInterval = 2 * time.Second
+	cfg.Consumer.ShutdownTimeout = 10 * time.Second
+	cfg.ClickHouse = defaultClickHouseConfig()
+	cfg.Metrics.Addr = ":9091"
+	cfg.Health.Timeout = 2 * time.Second
+	return cfg
+}
+
+func (c *Config) Validate() error {
+	var errs []error
+	if len(c.Kafka.Brokers) == 0 {
+		errs = append(errs, errors.New("kafka.brokers is required"))
+	}
+	if len(c.Kafka.Topics) == 0 {
+		errs = append(errs, errors.New("kafka.topics is required"))
+	}
+	if c.Kafka.GroupID == "" {
+		errs = append(errs, errors.New("kafka.group_id is required"))
+	}
+	if _, err := ParseStartOffset(c.Kafka.StartOffset); err != nil {
+		errs = append(errs, err)
+	}
+	if c.Consumer.BatchSize <= 0 {
+		errs = append(errs, errors.New("consumer.batch_size must be positive"))
+	}
+	if c.Consumer.FlushInterval <= 0 {
+		errs = append(errs, errors.New("consumer.flush_interval must be positive"))
+	}
+	if c.Health.Timeout <= 0 {
+		errs = append(errs, errors.New("health.timeout must be positive"))
+	}
+	errs = append(errs, c.ClickHouse.validate()...)
+	return errors.Join(errs...)
+}
+
+// ReplayOptions configures the replay subcommand. It shares the connection
+// settings, environment variables and config file format of the consumer.
+type ReplayOptions struct {
+	Kafka struct {
+		Brokers []string `yaml:"brokers" env:"KAFKA_BROKERS" flag:"brokers" usage:"Comma-separated Kafka brokers"`
+		Topic   string   `yaml:"topic" flag:"topic" usage:"Kafka topic to replay"`
+	} `yaml:"kafka"`
+
+	Replay struct {
+		From        string   `yaml:"from" flag:"from" usage:"Start of the range: earliest, an offset or an RFC3339 timestamp"`
+		To          string   `yaml:"to" flag:"to" usage:"End of the range (exclusive): latest, an offset or an RFC3339 timestamp"`
+		Services    []string `yaml:"services" flag:"service" usage:"Comma-separated services to replay (default all)"`
+		BatchSize   int      `yaml:"batch_size" flag:"batch-size" usage:"Maximum logs per ClickHouse insert"`
+		Dedup       bool     `yaml:"dedup" flag:"dedup" usage:"Skip logs already present in the target table"`
+		CreateTable bool     `yaml:"create_table" flag:"create-table" usage:"Create the target table with the schema of logs_db.logs if missing"`
+	} `yaml:"replay"`
+
+	ClickHouse ClickHouseConfig `yaml:"clickhouse"`
+}
+
+func defaultReplayOptions() *ReplayOptions {
+	opts := &ReplayOptions{}
+	opts.Kafka.Brokers = []string{"localhost:9092"}
+	opts.Kafka.Topic = "logs"
+	opts.Replay.From = "earliest"
+	opts.Replay.To = "latest"
+	opts.Replay.BatchSize = 1000
+	opts.Replay.Dedup = true
+	opts.ClickHouse = defaultClickHouseConfig()
+	return opts
+}
+
+func (o *ReplayOptions) Validate() error {
+	var errs []error
+	if len(o.Kafka.Brokers) == 0 {
+		errs = append(errs, errors.New("kafka.brokers is required"))
+	}
+	if o.Kafka.Topic == "" {
+		errs = append(errs, errors.New("kafka.topic is required"))
+	}
+	if o.Replay.BatchSize <= 0 {
+		errs = append(errs, errors.New("replay.batch_size must be positive"))
+	}
+	errs = append(errs, o.ClickHouse.validate()...)
+	return errors.Join(errs...)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/davidojo1144/LogStream/shared/logs"
+	"github.com/segmentio/kafka-go"
+)
+
+type ConsumerConfig struct {
+	Brokers       []string
+	Topics        []string
+	GroupID       string
+	StartOffset   StartOffset
+	BatchSize     int
+	FlushInterval time.Duration
+}
+
+type Consumer struct {
+	reader        *kafka.Reader
+	sink          *ClickHouseSink
+	batchSize     int
+	flushInterval time.Duration
+}
+
+func NewConsumer(ctx context.Context, cfg ConsumerConfig, sink *ClickHouseSink) (*Consumer, error) {
+	// kafka-go only understands first/last for a group's initial position, so
+	// a timestamp start is applied by committing the matching offsets before
+	// the reader joins the group.
+	if !cfg.StartOffset.At.IsZero() {
+		if err := seedGroupOffsets(ctx, cfg.Brokers, cfg.GroupID, cfg.Topics, cfg.StartOffset.At); err != nil {
+			return nil, err
+		}
+	}
+
+	// Initialize Kafka Reader. Offsets are committed explicitly once a batch
+	// has been written to ClickHouse, so a crash replays rather than drops.
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     cfg.Brokers,
+		GroupTopics: cfg.Topics,
+		GroupID:     cfg.GroupID,
+		StartOffset: cfg.StartOffset.Offset,
+		MinBytes:    1,    // Fetch immediately
+		MaxBytes:    10e6, // 10MB
+	})
+
+	return &Consumer{
+		reader:        reader,
+		sink:          sink,
+		batchSize:     cfg.BatchSize,
+		flushInterval: cfg.FlushInterval,
+	}, nil
+}
+
+// Start consumes until ctx is cancelled. On shutdown the batch in hand is
+// flushed and committed using a fresh context bounded by shutdownTimeout.
+func (c *Consumer) Start(ctx context.Context, shutdownTimeout time.Duration) {
+	log.Println("Starting Kafka Consumer...")
+
+	messages := make(chan kafka.Message)
+	go c.fetch(ctx, messages)
+
+	batch := newBatch(c.batchSize)
+	ticker := time.NewTicker(c.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			if err := c.flush(flushCtx, batch); err != nil {
+				log.Printf("Error flushing final batch of %d logs: %v", batch.Len(), err)
+			} else {
+				log.Println("Final batch flushed")
+			}
+			cancel()
+			return
+
+		case m := <-messages:
+			batch.Add(m)
+
+			// Insert if batch is full. While ClickHouse is failing we stop
+			// reading so the batch cannot grow without bound.
+			for batch.Len() >= c.batchSize {
+				err := c.flush(ctx, batch)
+				if err == nil {
+					break
+				}
+				log.Printf("Error inserting batch: %v", err)
+				select {
+				case <-ctx.Done():
+				case <-time.After(time.Second):
+				}
+				if ctx.Err() != nil {
+					break
+				}
+			}
+
+		case <-ticker.C:
+			kafkaLag.Set(float64(c.reader.Stats().Lag))
+
+			// Time-based flush
+			if err := c.flush(ctx, batch); err != nil {
+				log.Printf("Error inserting batch on tick: %v", err)
+			}
+		}
+	}
+}
+
+// fetch reads messages until ctx is cancelled.
+func (c *Consumer) fetch(ctx context.Context, out chan<- kafka.Message) {
+	for {
+		m, err := c.reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, io.EOF) {
+				return
+			}
+			log.Printf("Error reading message: %v", err)
+			continue
+		}
+
+		consumedMessages.Inc()
+		select {
+		case out <- m:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// flush writes the batch to ClickHouse and commits its offsets.
+func (c *Consumer) flush(ctx context.Context, b *batch) error {
+	if b.Len() == 0 {
+		return nil
+	}
+
+	if err := c.sink.Insert(ctx, b.entries[b.written:]); err != nil {
+		return err
+	}
+	b.written = len(b.entries)
+
+	if err := c.reader.CommitMessages(ctx, b.messages...); err != nil {
+		// The rows are already in ClickHouse, so a retry only commits. If the
+		// process dies first they are inserted again on restart, never dropped.
+		return fmt.Errorf("failed to commit offsets: %w", err)
+	}
+
+	b.Reset()
+	return nil
+}
+
+// Ping checks that the brokers answer and know every consumed topic.
+func (c *Consumer) Ping(ctx context.Context) error {
+	client := &kafka.Client{Addr: kafka.TCP(c.reader.Config().Brokers...)}
+	_, err := topicPartitions(ctx, client, c.reader.Config().GroupTopics)
+	return err
+}
+
+func (c *Consumer) Close() error {
+	return c.reader.Close()
+}
+
+// batch pairs decoded entries with the Kafka messages they came from so that
+// offsets are only committed for what has been written.
+type batch struct {
+	entries  []logs.Entry
+	messages []kafka.Message
+	written  int // Entries already in ClickHouse
+}
+
+func newBatch(size int) *batch {
+	return &batch{
+		entries:  make([]logs.Entry, 0, size),
+		messages: make([]kafka.Message, 0, size),
+	}
+}
+
+func (b *batch) Add(m kafka.Message) {
+	// Undecodable messages are still committed so they are skipped for good.
+	b.messages = append(b.messages, m)
+
+	var entry logs.Entry
+	if err := json.Unmarshal(m.Value, &entry); err != nil {
+		decodeErrors.Inc()
+		log.Printf("Error unmarshaling message at %s/%d@%d: %v", m.Topic, m.Partition, m.Offset, err)
+		return
+	}
+	// Messages from collectors older than level normalization still carry
+	// the raw level.
+	entry.Normalize()
+	b.entries = append(b.entries, entry)
+}
+
+func (b *batch) Len() int {
+	return len(b.messages)
+}
+
+func (b *batch) Reset() {
+	b.entries = b.entries[:0]
+	b.messages = b.messages[:0]
+	b.written = 0
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/davidojo1144/LogStream/shared/config"
+	"github.com/davidojo1144/LogStream/shared/health"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func main() {
+	// "consumer replay ..." re-ingests a range of the topic and exits.
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		runReplay(os.Args[2:])
+		return
+	}
+
+	log.Println("Starting Log Consumer Service...")
+
+	// Configuration
+	cfg := defaultConfig()
+	if err := config.Load(cfg, "consumer", os.Args[1:]); err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	start, _ := ParseStartOffset(cfg.Kafka.StartOffset)
+
+	// Graceful Shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Initialize ClickHouse Sink
+	sink, err := NewClickHouseSink(cfg.ClickHouse)
+	if err != nil {
+		log.Fatalf("Failed to initialize ClickHouse sink: %v", err)
+	}
+	defer sink.Close()
+
+	// Initialize Consumer
+	consumer, err := NewConsumer(ctx, ConsumerConfig{
+		Brokers:       cfg.Kafka.Brokers,
+		Topics:        cfg.Kafka.Topics,
+		GroupID:       cfg.Kafka.GroupID,
+		StartOffset:   start,
+		BatchSize:     cfg.Consumer.BatchSize,
+		FlushInterval: cfg.Consumer.FlushInterval,
+	}, sink)
+	if err != nil {
+		log.Fatalf("Failed to initialize consumer: %v", err)
+	}
+
+	// The consumer has no API, so metrics and probes get a listener of their own
+	if cfg.Metrics.Addr != "" {
+		probes := health.New(cfg.Health.Timeout,
+			health.Check{Name: "kafka", Critical: true, Check: consumer.Ping},
+			health.Check{Name: "clickhouse", Critical: true, Check: sink.Ping},
+		)
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.Handler())
+		mux.HandleFunc("/livez", probes.Livez)
+		mux.HandleFunc("/readyz", probes.Readyz)
+		go func() {
+			log.Printf("Serving metrics on %s", cfg.Metrics.Addr)
+			if err := http.ListenAndServe(cfg.Metrics.Addr, mux); err != nil {
+				log.Printf("Metrics server failed: %v", err)
+			}
+		}()
+	}
+
+	log.Printf("Consuming %s as group %s (start offset %s, batch size %d)", strings.Join(cfg.Kafka.Topics, ","), cfg.Kafka.GroupID, start, cfg.Consumer.BatchSize)
+	consumer.Start(ctx, cfg.Consumer.ShutdownTimeout)
+
+	log.Println("Shutting down consumer...")
+	if err := consumer.Close(); err != nil {
+		log.Printf("Error closing Kafka reader: %v", err)
+	}
+
+	log.Println("Consumer exited properly")
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	consumedMessages = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "logstream_consumer_messages_total",
+		Help: "Kafka messages fetched by the consumer.",
+	})
+
+	decodeErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "logstream_consumer_decode_errors_total",
+		Help: "Kafka messages skipped because they were not valid log entries.",
+	})
+
+	batchSizes = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "logstream_consumer_batch_size",
+		Help:    "Number of log entries per ClickHouse insert.",
+		Buckets: prometheus.ExponentialBuckets(1, 4, 8),
+	})
+
+	insertDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "logstream_consumer_insert_duration_seconds",
+		Help:    "Latency of ClickHouse batch inserts.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	insertErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "logstream_consumer_insert_errors_total",
+		Help: "Failed ClickHouse batch inserts.",
+	})
+
+	kafkaLag = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "logstream_consumer_kafka_lag",
+		Help: "Messages between the consumer's position and the end of the partition, as last reported by the reader.",
+	})
+)
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// StartOffset describes where a consumer group with no committed offsets
+// begins reading: the first or last offset of each partition, or the first
+// offset written at or after a point in time.
+type StartOffset struct {
+	Offset int64     // kafka.FirstOffset or kafka.LastOffset
+	At     time.Time // Set when starting from a timestamp
+}
+
+func ParseStartOffset(s string) (StartOffset, error) {
+	switch strings.ToLower(s) {
+	case "", "earliest", "first":
+		return StartOffset{Offset: kafka.FirstOffset}, nil
+	case "latest", "last":
+		return StartOffset{Offset: kafka.LastOffset}, nil
+	}
+
+	at, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return StartOffset{}, fmt.Errorf("start offset must be earliest, latest or an RFC3339 timestamp: %q", s)
+	}
+	return StartOffset{Offset: kafka.FirstOffset, At: at}, nil
+}
+
+func (s StartOffset) String() string {
+	switch {
+	case !s.At.IsZero():
+		return s.At.Format(time.RFC3339)
+	case s.Offset == kafka.LastOffset:
+		return "latest"
+	default:
+		return "earliest"
+	}
+}
+
+// topicPartitions returns the partition IDs of every topic.
+func topicPartitions(ctx context.Context, client *kafka.Client, topics []string) (map[string][]int, error) {
+	metadata, err := client.Metadata(ctx, &kafka.MetadataRequest{Topics: topics})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get topic metadata: %w", err)
+	}
+
+	partitions := make(map[string][]int, len(metadata.Topics))
+	for _, t := range metadata.Topics {
+		if t.Error != nil {
+			return nil, fmt.Errorf("failed to get metadata for topic %s: %w", t.Name, t.Error)
+		}
+		for _, p := range t.Partitions {
+			partitions[t.Name] = append(partitions[t.Name], p.ID)
+		}
+	}
+	return partitions, nil
+}
+
+// offsetsAt returns, for each partition of topic, the first offset whose
+// message timestamp is at or after the given time. Partitions with no such
+// message resolve to their high watermark.
+func offsetsAt(ctx context.Context, client *kafka.Client, topic string, partitions []int, at time.Time) (map[int]int64, error) {
+	timeReqs := make([]kafka.OffsetRequest, len(partitions))
+	lastReqs := make([]kafka.OffsetRequest, len(partitions))
+	for i, p := range partitions {
+		timeReqs[i] = kafka.TimeOffsetOf(p, at)
+		lastReqs[i] = kafka.LastOffsetOf(p)
+	}
+
+	// The two lookups are sent separately because a timestamp lookup with no
+	// match comes back looking like a last-offset answer of -1.
+	last, err := client.ListOffsets(ctx, &kafka.ListOffsetsRequest{Topics: map[string][]kafka.OffsetRequest{topic: lastReqs}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list offsets: %w", err)
+	}
+	timed, err := client.ListOffsets(ctx, &kafka.ListOffsetsRequest{Topics: map[string][]kafka.OffsetRequest{topic: timeReqs}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list offsets: %w", err)
+	}
+
+	offsets := make(map[int]int64, len(partitions))
+	for _, p := range last.Topics[topic] {
+		if p.Error != nil {
+			return nil, fmt.Errorf("failed to list offsets for %s/%d: %w", topic, p.Partition, p.Error)
+		}
+		offsets[p.Partition] = p.LastOffset
+	}
+	for _, p := range timed.Topics[topic] {
+		for offset := range p.Offsets {
+			if offset >= 0 && offset < offsets[p.Partition] {
+				offsets[p.Partition] = offset
+			}
+		}
+	}
+	return offsets, nil
+}
+
+// seedGroupOffsets commits the offsets found at the given time for every
+// partition on which the group has not committed anything yet. Partitions
+// the group already tracks are left alone, so restarting a consumer never
+// rewinds it.
+func seedGroupOffsets(ctx context.Context, brokers []string, groupID string, topics []string, at time.Time) error {
+	client := &kafka.Client{Addr: kafka.TCP(brokers...), Timeout: 10 * time.Second}
+
+	partitions, err := topicPartitions(ctx, client, topics)
+	if err != nil {
+		return err
+	}
+
+	committed, err := client.OffsetFetch(ctx, &kafka.OffsetFetchRequest{GroupID: groupID, Topics: partitions})
+	if err != nil {
+		return fmt.Errorf("failed to fetch group offsets: %w", err)
+	}
+	if committed.Error != nil {
+		return fmt.Errorf("failed to fetch group offsets: %w", committed.Error)
+	}
+
+	commits := make(map[string][]kafka.OffsetCommit)
+	for topic, ids := range partitions {
+		tracked := make(map[int]bool)
+		for _, p := range committed.Topics[topic] {
+			if p.CommittedOffset >= 0 {
+				tracked[p.Partition] = true
+			}
+		}
+
+		var unset []int
+		for _, id := range ids {
+			if !tracked[id] {
+				unset = append(unset, id)
+			}
+		}
+		if len(unset) == 0 {
+			continue
+		}
+
+		offsets, err := offsetsAt(ctx, client, topic, unset, at)
+		if err != nil {
+			return err
+		}
+		for _, p := range unset {
+			commits[topic] = append(commits[topic], kafka.OffsetCommit{Partition: p, Offset: offsets[p]})
+		}
+	}
+
+	if len(commits) == 0 {
+		return nil
+	}
+
+	// A generation of -1 with no member ID is accepted by the coordinator as
+	// long as the group has no active members, which is the case before our
+	// reader joins.
+	res, err := client.OffsetCommit(ctx, &kafka.OffsetCommitRequest{
+		GroupID:      groupID,
+		GenerationID: -1,
+		Topics:       commits,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to commit start offsets: %w", err)
+	}
+	for topic, parts := range res.Topics {
+		for _, p := range parts {
+			if p.Error != nil {
+				return fmt.Errorf("failed to commit start offset for %s/%d: %w", topic, p.Partition, p.Error)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/davidojo1144/LogStream/shared/config"
+	"github.com/davidojo1144/LogStream/shared/logs"
+	"github.com/segmentio/kafka-go"
+)
+
+// replayBound is one end of a replay range: a partition's first or last
+// offset, an explicit offset, or the first offset at or after a timestamp.
+type replayBound struct {
+	Offset int64
+	At     time.Time
+}
+
+func parseReplayBound(s string, def int64) (replayBound, error) {
+	switch strings.ToLower(s) {
+	case "":
+		return replayBound{Offset: def}, nil
+	case "earliest", "first":
+		return replayBound{Offset: kafka.FirstOffset}, nil
+	case "latest", "last":
+		return replayBound{Offset: kafka.LastOffset}, nil
+	}
+
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil && n >= 0 {
+		return replayBound{Offset: n}, nil
+	}
+	at, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return replayBound{}, fmt.Errorf("must be earliest, latest, an offset or an RFC3339 timestamp: %q", s)
+	}
+	return replayBound{At: at}, nil
+}
+
+type ReplayConfig struct {
+	Brokers   []string
+	Topic     string
+	From      replayBound
+	To        replayBound
+	Services  map[string]bool // Empty means every service
+	BatchSize int
+	Dedup     bool
+}
+
+type ReplayStats struct {
+	Read       int64
+	Filtered   int64
+	Duplicates int64
+	Written    int64
+}
+
+// Replayer re-reads a range of a topic into ClickHouse. It reads partitions
+// directly without joining a consumer group, so the live consumer's offsets
+// are never touched.
+type Replayer struct {
+	cfg   ReplayConfig
+	sink  *ClickHouseSink
+	stats ReplayStats
+}
+
+func NewReplayer(cfg ReplayConfig, sink *ClickHouseSink) *Replayer {
+	return &Replayer{cfg: cfg, sink: sink}
+}
+
+func (r *Replayer) Run(ctx context.Context) (ReplayStats, error) {
+	client := &kafka.Client{Addr: kafka.TCP(r.cfg.Brokers...), Timeout: 10 * time.Second}
+
+	partitions, err := topicPartitions(ctx, client, []string{r.cfg.Topic})
+	if err != nil {
+		return r.stats, err
+	}
+	ids := partitions[r.cfg.Topic]
+
+	starts, err := r.resolve(ctx, client, ids, r.cfg.From)
+	if err != nil {
+		return r.stats, fmt.Errorf("failed to resolve start offsets: %w", err)
+	}
+	ends, err := r.resolve(ctx, client, ids, r.cfg.To)
+	if err != nil {
+		return r.stats, fmt.Errorf("failed to resolve end offsets: %w", err)
+	}
+	// Nothing past the high watermark can be read yet.
+	latest, err := r.resolve(ctx, client, ids, replayBound{Offset: kafka.LastOffset})
+	if err != nil {
+		return r.stats, fmt.Errorf("failed to resolve end offsets: %w", err)
+	}
+	for _, p := range ids {
+		ends[p] = min(ends[p], latest[p])
+	}
+
+	for _, p := range ids {
+		if starts[p] >= ends[p] {
+			log.Printf("Partition %d: nothing to replay", p)
+			continue
+		}
+		log.Printf("Partition %d: replaying offsets %d to %d", p, starts[p], ends[p]-1)
+		if err := r.replayPartition(ctx, p, starts[p], ends[p]); err != nil {
+			return r.stats, fmt.Errorf("partition %d: %w", p, err)
+		}
+	}
+	return r.stats, nil
+}
+
+// resolve turns a bound into a concrete offset per partition. End bounds are
+// exclusive, so a timestamp end stops before the first message at that time.
+func (r *Replayer) resolve(ctx context.Context, client *kafka.Client, partitions []int, b replayBound) (map[int]int64, error) {
+	if !b.At.IsZero() {
+		return offsetsAt(ctx, client, r.cfg.Topic, partitions, b.At)
+	}
+
+	offsets := make(map[int]int64, len(partitions))
+	switch b.Offset {
+	case kafka.FirstOffset, kafka.LastOffset:
+		reqs := make([]kafka.OffsetRequest, len(partitions))
+		for i, p := range partitions {
+			reqs[i] = kafka.OffsetRequest{Partition: p, Timestamp: b.Offset}
+		}
+		res, err := client.ListOffsets(ctx, &kafka.ListOffsetsRequest{Topics: map[string][]kafka.OffsetRequest{r.cfg.Topic: reqs}})
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range res.Topics[r.cfg.Topic] {
+			if p.Error != nil {
+				return nil, p.Error
+			}
+			if b.Offset == kafka.FirstOffset {
+				offsets[p.Partition] = p.FirstOffset
+			} else {
+				offsets[p.Partition] = p.LastOffset
+			}
+		}
+	default:
+		for _, p := range partitions {
+			offsets[p] = b.Offset
+		}
+	}
+	return offsets, nil
+}
+
+// replayIdle is how long a read may wait for the next message before the
+// partition's replay fails as unfinished.
+const replayIdle = 15 * time.Second
+
+func (r *Replayer) replayPartition(ctx context.Context, partition int, start, end int64) error {
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:   r.cfg.Brokers,
+		Topic:     r.cfg.Topic,
+		Partition: partition,
+		MinBytes:  1,
+		MaxBytes:  10e6,
+	})
+	defer reader.Close()
+
+	if err := reader.SetOffset(start); err != nil {
+		return fmt.Errorf("failed to seek to offset %d: %w", start, err)
+	}
+
+	batch := make([]logs.Entry, 0, r.cfg.BatchSize)
+	for reader.Offset() < end {
+		readCtx, cancel := context.WithTimeout(ctx, replayIdle)
+		m, err := reader.ReadMessage(readCtx)
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				// Interrupted: keep what was already read.
+				flushCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+				defer cancel()
+				if err := r.write(flushCtx, batch); err != nil {
+					return err
+				}
+				return ctx.Err()
+			}
+			if errors.Is(err, context.DeadlineExceeded) {
+				// The range ends below the high watermark, so the
+				// missing offsets were never delivered. Keep what was
+				// read; with dedup a rerun only adds the rest.
+				if err := r.write(ctx, batch); err != nil {
+					return err
+				}
+				return fmt.Errorf("no message for %s at offset %d, before the end of the range at %d", replayIdle, reader.Offset(), end)
+			}
+			return fmt.Errorf("failed to read message: %w", err)
+		}
+		if m.Offset >= end {
+			break
+		}
+		r.stats.Read++
+
+		var entry logs.Entry
+		if err := json.Unmarshal(m.Value, &entry); err != nil {
+			log.Printf("Skipping undecodable message at offset %d: %v", m.Offset, err)
+		} else if len(r.cfg.Services) > 0 && !r.cfg.Services[entry.Service] {
+			r.stats.Filtered++
+		} else {
+			entry.Normalize()
+			batch = append(batch, entry)
+		}
+
+		if len(batch) >= r.cfg.BatchSize {
+			if err := r.write(ctx, batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	return r.write(ctx, batch)
+}
+
+func (r *Replayer) write(ctx context.Context, batch []logs.Entry) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	if r.cfg.Dedup {
+		existing, err := r.sink.Existing(ctx, batch)
+		if err != nil {
+			return fmt.Errorf("failed to check for duplicates: %w", err)
+		}
+
+		fresh := batch[:0:0]
+		for _, e := range batch {
+			key := dedupKey(e)
+			if existing[key] {
+				r.stats.Duplicates++
+				continue
+			}
+			// Also drop repeats within the batch itself.
+			existing[key] = true
+			fresh = append(fresh, e)
+		}
+		batch = fresh
+	}
+
+	if err := r.sink.Insert(ctx, batch); err != nil {
+		return fmt.Errorf("failed to insert batch: %w", err)
+	}
+	r.stats.Written += int64(len(batch))
+	return nil
+}
+
+func runReplay(args []string) {
+	opts := defaultReplayOptions()
+	if err := config.Load(opts, "replay", args); err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	if err := opts.Validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	fromBound, err := parseReplayBound(opts.Replay.From, kafka.FirstOffset)
+	if err != nil {
+		log.Fatalf("Invalid -from: %v", err)
+	}
+	toBound, err := parseReplayBound(opts.Replay.To, kafka.LastOffset)
+	if err != nil {
+		log.Fatalf("Invalid -to: %v", err)
+	}
+
+	serviceSet := make(map[string]bool)
+	for _, s := range opts.Replay.Services {
+		serviceSet[s] = true
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	sink, err := NewClickHouseSink(opts.ClickHouse)
+	if err != nil {
+		log.Fatalf("Failed to initialize ClickHouse sink: %v", err)
+	}
+	defer sink.Close()
+
+	if opts.Replay.CreateTable {
+		if err := sink.CreateLike(ctx, "logs_db.logs"); err != nil {
+			log.Fatalf("Failed to create table %s: %v", opts.ClickHouse.Table, err)
+		}
+	}
+
+	log.Printf("Replaying %s from %s to %s into %s", opts.Kafka.Topic, opts.Replay.From, opts.Replay.To, opts.ClickHouse.Table)
+	stats, err := NewReplayer(ReplayConfig{
+		Brokers:   opts.Kafka.Brokers,
+		Topic:     opts.Kafka.Topic,
+		From:      fromBound,
+		To:        toBound,
+		Services:  serviceSet,
+		BatchSize: opts.Replay.BatchSize,
+		Dedup:     opts.Replay.Dedup,
+	}, sink).Run(ctx)
+
+	log.Printf("Replay read %d, filtered %d, skipped %d duplicates, wrote %d", stats.Read, stats.Filtered, stats.Duplicates, stats.Written)
+	if err != nil {
+		log.Fatalf("Replay failed: %v", err)
+	}
+}
@@ -9,3 +9,34 @@ CREATE TABLE IF NOT EXISTS logs_db.logs (
 ) ENGINE = MergeTree()
 PARTITION BY toYYYYMMDD(timestamp)
 ORDER BY (service, timestamp);
+
+-- Bloom filter skip indexes for metadata filters (metadata.<key>=value and
+-- key-exists). On an existing table, build them for old parts with
+-- ALTER TABLE logs_db.logs MATERIALIZE INDEX <name>.
+ALTER TABLE logs_db.logs ADD INDEX IF NOT EXISTS idx_metadata_keys mapKeys(metadata) TYPE bloom_filter(0.01) GRANULARITY 1;
+ALTER TABLE logs_db.logs ADD INDEX IF NOT EXISTS idx_metadata_values mapValues(metadata) TYPE bloom_filter(0.01) GRANULARITY 1;
+
+-- Levels are normalized at ingest: level holds the canonical name, raw_level
+-- what the client sent and severity the OpenTelemetry severity number. For
+-- rows written before that, raw_level and severity are derived from level.
+-- The level names match levelAliases in shared/logs/level.go.
+ALTER TABLE logs_db.logs ADD COLUMN IF NOT EXISTS raw_level LowCardinality(String) DEFAULT level;
+ALTER TABLE logs_db.logs ADD COLUMN IF NOT EXISTS severity UInt8 DEFAULT multiIf(
+    lower(trim(level)) IN ('trace', 'verbose'), 1,
+    lower(trim(level)) IN ('debug', 'dbg'), 5,
+    lower(trim(level)) IN ('warn', 'warning'), 13,
+    lower(trim(level)) IN ('error', 'err'), 17,
+    lower(trim(level)) IN ('fatal', 'critical', 'crit', 'panic', 'alert', 'emerg', 'emergency'), 21,
+    9);
+ALTER TABLE logs_db.logs ADD INDEX IF NOT EXISTS idx_severity severity TYPE minmax GRANULARITY 1;
+
+-- Full-text search on message (see shared/logquery/fulltext.go). Words and phrases use the
+-- token index through hasToken(lower(message), ...), prefixes the ngram index
+-- through lower(message) LIKE.
+ALTER TABLE logs_db.logs ADD INDEX IF NOT EXISTS idx_message_tokens lower(message) TYPE tokenbf_v1(32768, 3, 0) GRANULARITY 1;
+ALTER TABLE logs_db.logs ADD INDEX IF NOT EXISTS idx_message_ngrams lower(message) TYPE ngrambf_v1(3, 65536, 3, 0) GRANULARITY 1;
+
+-- Log IDs are ULIDs assigned at ingest (see shared/logs/id.go). Rows written before
+-- that have an empty id and are identified by timestamp and tiebreak instead.
+ALTER TABLE logs_db.logs ADD COLUMN IF NOT EXISTS id String DEFAULT '';
+ALTER TABLE logs_db.logs ADD INDEX IF NOT EXISTS idx_id id TYPE bloom_filter(0.001) GRANULARITY 1;
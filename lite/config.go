@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/davidojo1144/LogStream/shared/auth"
+	"github.com/davidojo1144/LogStream/shared/ingest"
+	"github.com/davidojo1144/LogStream/shared/logquery"
+	"github.com/davidojo1144/LogStream/shared/serve"
+)
+
+type Config struct {
+	Server struct {
+		// Render and similar hosts inject PORT, so lite takes a port rather
+		// than a full listen address.
+		Port string `yaml:"port" env:"PORT" flag:"port" usage:"HTTP listen port"`
+	} `yaml:"server"`
+
+	TLS serve.TLSConfig `yaml:"tls"`
+
+	Ingest struct {
+		RequireClientCert bool `yaml:"require_client_cert" env:"INGEST_REQUIRE_CLIENT_CERT" flag:"ingest-require-client-cert" usage:"Reject /ingest requests without a verified client certificate"`
+	} `yaml:"ingest"`
+
+	Health struct {
+		Timeout time.Duration `yaml:"timeout" env:"HEALTH_TIMEOUT" flag:"health-timeout" usage:"Per-dependency timeout for /readyz checks"`
+	} `yaml:"health"`
+
+	Auth auth.Config `yaml:"auth"`
+
+	Limits ingest.LimitsConfig `yaml:"limits"`
+
+	Export logquery.ExportConfig `yaml:"export"`
+
+	Metering struct {
+		FlushInterval time.Duration `yaml:"flush_interval" env:"METERING_FLUSH_INTERVAL" flag:"metering-flush-interval" usage:"How often per-key ingest volume is added to Postgres"`
+	} `yaml:"metering"`
+
+	// Session verifies tokens of users signed in to the web app. Without
+	// a secret only API keys can read.
+	Session struct {
+		Secret string `yaml:"secret" env:"NEXTAUTH_SECRET" usage:"The web app's NEXTAUTH_SECRET, to accept its session tokens" secret:"true"`
+	} `yaml:"session"`
+
+	CORS struct {
+		AllowedOrigins []string `yaml:"allowed_origins" env:"CORS_ALLOWED_ORIGINS" flag:"cors-allowed-origins" usage:"Comma-separated browser origins allowed to call the API, or *"`
+	} `yaml:"cors"`
+
+	Postgres struct {
+		URL string `yaml:"url" env:"DATABASE_URL" flag:"database-url" usage:"Postgres connection string for logs and API keys" secret:"true"`
+	} `yaml:"postgres"`
+}
+
+func defaultConfig() *Config {
+	cfg := &Config{}
+	cfg.Server.Port = "8080"
+	cfg.TLS.ReloadInterval = time.Minute
+	cfg.Health.Timeout = 2 * time.Second
+	cfg.CORS.AllowedOrigins = []string{"http://localhost:3000"}
+	cfg.Auth.CacheTTL = time.Minute
+	cfg.Auth.NegativeCacheTTL = 10 * time.Second
+	cfg.Auth.StaleTTL = time.Hour
+	cfg.Auth.Listen = true
+	cfg.Auth.AllowPlaintextKeys = true
+	cfg.Auth.UsageFlushInterval = 30 * time.Second
+	cfg.Limits.EntriesPerSecond = 1000
+	cfg.Limits.BytesPerSecond = 10 << 20
+	cfg.Limits.Burst = 2 * time.Second
+	cfg.Limits.FlushInterval = 10 * time.Second
+	cfg.Metering.FlushInterval = time.Minute
+	cfg.Export.MaxRows = 100000
+	cfg.Export.AdminMaxRows = 1000000
+	return cfg
+}
+
+func (c *Config) Validate() error {
+	var errs []error
+	if c.Server.Port == "" {
+		errs = append(errs, errors.New("server.port is required"))
+	}
+	if c.Postgres.URL == "" {
+		errs = append(errs, errors.New("postgres.url is required (DATABASE_URL)"))
+	}
+	if c.Health.Timeout <= 0 {
+		errs = append(errs, errors.New("health.timeout must be positive"))
+	}
+	errs = append(errs, c.TLS.Validate()...)
+	errs = append(errs, c.Auth.Validate()...)
+	errs = append(errs, c.Limits.Validate()...)
+	errs = append(errs, c.Export.Validate()...)
+	if c.Metering.FlushInterval <= 0 {
+		errs = append(errs, errors.New("metering.flush_interval must be positive"))
+	}
+	if c.Ingest.RequireClientCert && c.TLS.ClientCAFile == "" {
+		errs = append(errs, errors.New("ingest.require_client_cert requires tls.client_ca_file"))
+	}
+	return errors.Join(errs...)
+}
@@ -1,14 +1,30 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/davidojo1144/LogStream/shared/auth"
+	"github.com/davidojo1144/LogStream/shared/config"
+	"github.com/davidojo1144/LogStream/shared/health"
+	"github.com/davidojo1144/LogStream/shared/ingest"
+	"github.com/davidojo1144/LogStream/shared/logquery"
+	"github.com/davidojo1144/LogStream/shared/logs"
+	"github.com/davidojo1144/LogStream/shared/metrics"
+	"github.com/davidojo1144/LogStream/shared/serve"
 	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // LiteServer combines Collector and API into a single binary
@@ -17,14 +33,14 @@ func main() {
 	log.Println("Starting LogStream LITE (Unified Server)...")
 
 	// Configuration
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	cfg := defaultConfig()
+	if err := config.Load(cfg, "logstream-lite", os.Args[1:]); err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
 	}
-	dbUrl := os.Getenv("DATABASE_URL")
-	if dbUrl == "" {
-		log.Fatal("DATABASE_URL environment variable is required")
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
 	}
+	dbUrl := cfg.Postgres.URL
 
 	// Initialize Postgres Connection (Shared for Auth and Logs)
 	pgProducer, err := NewPostgresProducer(dbUrl)
@@ -34,68 +50,165 @@ func main() {
 	defer pgProducer.Close()
 
 	// Initialize Auth Validator
-	validator, err := NewApiKeyValidator(dbUrl)
+	validator, err := auth.NewValidator(dbUrl, cfg.Auth)
 	if err != nil {
 		log.Fatalf("Failed to connect to Postgres for Auth: %v", err)
 	}
 	defer validator.Close()
 
+	var sessions *auth.SessionVerifier
+	if cfg.Session.Secret != "" {
+		sessions = auth.NewSessionVerifier(cfg.Session.Secret)
+	}
+	limiter := ingest.NewLimiter(pgProducer.db, cfg.Limits)
+	defer limiter.Close()
+	meter := ingest.NewMeter(pgProducer.db, cfg.Metering.FlushInterval)
+	defer meter.Close()
+
+	readAuth := auth.NewReadAuth(validator, sessions)
+	cors := serve.NewCORS(cfg.CORS.AllowedOrigins)
+	upgrader.CheckOrigin = cors.CheckOrigin
+	searches := logquery.NewSavedSearches(pgProducer.db, readAuth)
+
 	// 1. COLLECTOR HANDLER
 	// In Lite mode, we adapt the PostgresProducer to match the interface expected by LogHandler
 	// We need to refactor LogHandler to accept an interface instead of *KafkaProducer
 	// For now, let's create a quick adapter or modify Handler.
 	
 	// Since we are in a hurry, I will implement a specific LiteHandler here
-	http.HandleFunc("/ingest", func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc("/ingest", metrics.Instrument("/ingest", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
-		apiKey := r.Header.Get("Authorization")
-		if apiKey == "" || !validator.Validate(apiKey) {
-			http.Error(w, "Invalid API Key", http.StatusUnauthorized)
+		// A verified client certificate stands in for the API key
+		var key *auth.ApiKey
+		if subject := serve.ClientCertSubject(r); subject != "" {
+			if key = validator.ValidateCertSubject(subject); key == nil {
+				metrics.AuthFailures.WithLabelValues("unknown_certificate").Inc()
+				http.Error(w, "Client certificate is not linked to an active API Key", http.StatusUnauthorized)
+				return
+			}
+		} else if cfg.Ingest.RequireClientCert {
+			metrics.AuthFailures.WithLabelValues("missing_certificate").Inc()
+			http.Error(w, "Client certificate required", http.StatusUnauthorized)
+			return
+		} else {
+			apiKey := r.Header.Get("Authorization")
+			if apiKey == "" {
+				metrics.AuthFailures.WithLabelValues("invalid_key").Inc()
+				http.Error(w, "Invalid API Key", http.StatusUnauthorized)
+				return
+			}
+			if key = validator.Validate(apiKey); key == nil {
+				metrics.AuthFailures.WithLabelValues("invalid_key").Inc()
+				http.Error(w, "Invalid API Key", http.StatusUnauthorized)
+				return
+			}
+		}
+		if !key.HasScope(auth.ScopeIngest) {
+			metrics.AuthFailures.WithLabelValues("missing_scope").Inc()
+			http.Error(w, "API Key does not have the ingest scope", http.StatusForbidden)
 			return
 		}
-
-		var entry LogEntry
-		if err := json.NewDecoder(r.Body).Decode(&entry); err != nil {
+		validator.Used(key, r)
+
+		body := &ingest.CountingReader{R: http.MaxBytesReader(w, r.Body, ingest.MaxBodyBytes)}
+		var entry logs.Entry
+		if err := json.NewDecoder(body).Decode(&entry); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, fmt.Sprintf("Request body exceeds %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+				return
+			}
 			http.Error(w, "Invalid body", http.StatusBadRequest)
 			return
 		}
+		// Only Normalize sets raw_level: one sent by the client would take
+		// priority over its level, bypassing the key's level restrictions.
+		entry.RawLevel = ""
+		entry.Normalize()
+		if !key.Allows(entry.Service, entry.Level) {
+			metrics.AuthFailures.WithLabelValues("restricted").Inc()
+			http.Error(w, fmt.Sprintf("API Key may not ingest %q logs for service %q", entry.Level, entry.Service), http.StatusForbidden)
+			return
+		}
+
+		decision := limiter.Allow(key, 1, body.N)
+		decision.SetHeaders(w)
+		if !decision.Allowed {
+			metrics.RateLimited.WithLabelValues(decision.Limit).Inc()
+			http.Error(w, decision.Reason, decision.Status)
+			return
+		}
 		if entry.Timestamp.IsZero() {
 			entry.Timestamp = time.Now().UTC()
 		}
+		// IDs are always assigned here so they stay unique
+		entry.ID = logs.NewID(entry.Timestamp)
 
 		// Write to Postgres SYNCHRONOUSLY to catch errors
 		if err := pgProducer.WriteLog(entry); err != nil {
+			producerErrors.Inc()
 			log.Printf("Error writing log to DB: %v", err)
 			http.Error(w, "Failed to write log to database", http.StatusInternalServerError)
 			return
 		}
 
-		// Broadcast to WebSockets (Async is fine here)
-		go broadcastLog(entry)
+		metrics.CountIngest(entry.Service, body.N)
+		meter.Record(key, entry.Service, 1, body.N)
+
+		// Broadcast to WebSockets. This only queues the entry for each client.
+		broadcastLog(entry)
 
 		w.WriteHeader(http.StatusAccepted)
 		w.Write([]byte(`{"status":"accepted"}`))
-	})
+	}))
 
 	// 2. API HANDLERS (Read from Postgres)
-	http.HandleFunc("/logs", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
+	http.HandleFunc("/logs", metrics.Instrument("/logs", cors.Wrap(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 
+		key, ok := readAuth.Authorize(w, r)
+		if !ok {
+			return
+		}
+
 		// Parse Query Params
-		query := r.URL.Query()
+		query, err := searches.Expand(r.Context(), r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		service := query.Get("service")
-		level := query.Get("level")
-		search := query.Get("search")
 		startTime := query.Get("start_time")
 		endTime := query.Get("end_time")
+		filter, err := logquery.ParseQuery(query.Get("q"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		metadata, err := logquery.MetadataFilter(query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter = logquery.AndQuery(logquery.AndQuery(filter, metadata), logquery.SearchQuery(query.Get("search")))
+		cursor, err := logquery.ParseCursor(query.Get("cursor"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		limit := 100
+		if l := query.Get("limit"); l != "" {
+			if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 {
+				limit = parsed
+			}
+		}
 
 		// Build SQL Query
-		sql := `SELECT timestamp, service, level, message, metadata FROM "Log" WHERE 1=1`
+		sql := `SELECT id, timestamp, service, level, COALESCE("rawLevel", level), severity, message, metadata FROM "Log" WHERE 1=1`
 		var args []interface{}
 		argId := 1
 
@@ -104,16 +217,6 @@ func main() {
 			args = append(args, service)
 			argId++
 		}
-		if level != "" {
-			sql += fmt.Sprintf(" AND level = $%d", argId)
-			args = append(args, level)
-			argId++
-		}
-		if search != "" {
-			sql += fmt.Sprintf(" AND message ILIKE $%d", argId)
-			args = append(args, "%"+search+"%")
-			argId++
-		}
 		if startTime != "" {
 			sql += fmt.Sprintf(" AND timestamp >= $%d", argId)
 			args = append(args, startTime)
@@ -124,13 +227,36 @@ func main() {
 			args = append(args, endTime)
 			argId++
 		}
+		sql, args, argId, err = levelQuery(sql, args, argId, query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sql, args, argId = filterQuery(sql, args, argId, filter)
+		sql, args, argId = restrictQuery(sql, args, argId, key)
 
-		sql += " ORDER BY timestamp DESC LIMIT 100"
+
+		// Page by (timestamp, id) so entries sharing a timestamp are neither
+		// skipped nor repeated.
+		order := "DESC"
+		if cursor != nil {
+			cmp := "<"
+			if cursor.Direction == logquery.CursorPrev {
+				cmp, order = ">", "ASC"
+			}
+			sql += fmt.Sprintf(" AND (timestamp, id) %s ($%d, $%d)", cmp, argId, argId+1)
+			args = append(args, cursor.Timestamp, cursor.ID)
+			argId += 2
+		}
+		sql += fmt.Sprintf(" ORDER BY timestamp %s, id %s LIMIT $%d", order, order, argId)
+		args = append(args, limit+1)
 
 		log.Printf("Executing Logs Query: %s params: %v", sql, args)
 
 		// Execute Query
+		start := time.Now()
 		rows, err := pgProducer.db.Query(sql, args...)
+		metrics.ObserveQuery("logs", start, &err)
 		if err != nil {
 			log.Printf("Error querying logs: %v", err)
 			http.Error(w, "Failed to fetch logs", http.StatusInternalServerError)
@@ -138,68 +264,219 @@ func main() {
 		}
 		defer rows.Close()
 
-		var logs []LogEntry
+		var entries []logs.Entry
+		var ids []string
 		for rows.Next() {
-			var l LogEntry
+			var l logs.Entry
 			var metadataBytes []byte
-			if err := rows.Scan(&l.Timestamp, &l.Service, &l.Level, &l.Message, &metadataBytes); err != nil {
+			if err := rows.Scan(&l.ID, &l.Timestamp, &l.Service, &l.Level, &l.RawLevel, &l.Severity, &l.Message, &metadataBytes); err != nil {
 				continue
 			}
 			if len(metadataBytes) > 0 {
 				json.Unmarshal(metadataBytes, &l.Metadata)
 			}
-			logs = append(logs, l)
+			entries = append(entries, l)
+			ids = append(ids, l.ID)
+		}
+
+		page := logquery.NewLogPage(entries, ids, limit, cursor)
+		logquery.Highlight(page.Logs, filter)
+		json.NewEncoder(w).Encode(page)
+	})))
+
+	http.HandleFunc("/logs/", metrics.Instrument("/logs/id", cors.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		key, ok := readAuth.Authorize(w, r)
+		if !ok {
+			return
+		}
+
+		// Routes under /logs/ are /logs/{id} and /logs/{id}/context
+		id, withContext := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/logs/"), "/context")
+		if id == "" || strings.Contains(id, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		// IDs handed out before IDs were stored in responses wrap the row ID
+		if ref, err := logquery.ParseLogID(id); err == nil {
+			id = ref.ID
+		}
+		params, err := logquery.ParseContextParams(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
 
-		if logs == nil {
-			logs = []LogEntry{}
+		// queryLogs runs a query selecting the /logs columns
+		const columns = `SELECT id, timestamp, service, level, COALESCE("rawLevel", level), severity, message, metadata FROM "Log"`
+		queryLogs := func(sql string, args []interface{}) ([]logs.Entry, error) {
+			rows, err := pgProducer.db.Query(sql, args...)
+			if err != nil {
+				return nil, err
+			}
+			defer rows.Close()
+
+			entries := []logs.Entry{}
+			for rows.Next() {
+				var l logs.Entry
+				var metadataBytes []byte
+				if err := rows.Scan(&l.ID, &l.Timestamp, &l.Service, &l.Level, &l.RawLevel, &l.Severity, &l.Message, &metadataBytes); err != nil {
+					return nil, err
+				}
+				if len(metadataBytes) > 0 {
+					json.Unmarshal(metadataBytes, &l.Metadata)
+				}
+				entries = append(entries, l)
+			}
+			return entries, rows.Err()
 		}
 
-		json.NewEncoder(w).Encode(logs)
-	})
+		start := time.Now()
+		lc, err := func() (*logquery.LogContext, error) {
+			sql, args, _ := restrictQuery(columns+` WHERE id = $1`, []interface{}{id}, 2, key)
+			entries, err := queryLogs(sql, args)
+			if err != nil || len(entries) == 0 {
+				return nil, err
+			}
+			lc := &logquery.LogContext{Log: entries[0]}
+			if !withContext {
+				return lc, nil
+			}
+
+			// The same (timestamp, id) order as /logs
+			pivot := lc.Log
+			filter := params.Filter(pivot)
+			side := func(cmp, order string, limit int) ([]logs.Entry, error) {
+				return logquery.ContextSide(limit, func(window time.Duration) ([]logs.Entry, error) {
+					bound, edge := ">=", pivot.Timestamp.Add(-window)
+					if cmp == ">" {
+						bound, edge = "<=", pivot.Timestamp.Add(window)
+					}
+					sql := columns + ` WHERE (timestamp, id) ` + cmp + ` ($1, $2) AND timestamp ` + bound + ` $3`
+					args := []interface{}{pivot.Timestamp, pivot.ID, edge}
+					sql, args, argId := filterQuery(sql, args, 4, filter)
+					sql, args, argId = restrictQuery(sql, args, argId, key)
+					sql += fmt.Sprintf(" ORDER BY timestamp %s, id %s LIMIT $%d", order, order, argId)
+					return queryLogs(sql, append(args, limit))
+				})
+			}
+
+			if lc.Before, err = side("<", "DESC", params.Before); err != nil {
+				return nil, err
+			}
+			slices.Reverse(lc.Before)
+			if lc.After, err = side(">", "ASC", params.After); err != nil {
+				return nil, err
+			}
+			return lc, nil
+		}()
+		if withContext {
+			metrics.ObserveQuery("context", start, &err)
+		} else {
+			metrics.ObserveQuery("log", start, &err)
+		}
+		if err != nil {
+			log.Printf("Error querying log: %v", err)
+			http.Error(w, "Failed to fetch log", http.StatusInternalServerError)
+			return
+		}
+		if lc == nil {
+			http.Error(w, "log not found", http.StatusNotFound)
+			return
+		}
 
-	http.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
+		if withContext {
+			json.NewEncoder(w).Encode(lc)
+		} else {
+			json.NewEncoder(w).Encode(lc.Log)
+		}
+	})))
+
+	http.HandleFunc("/stats", metrics.Instrument("/stats", cors.Wrap(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 
+		key, ok := readAuth.Authorize(w, r)
+		if !ok {
+			return
+		}
+
 		// Parse Query Params
-		query := r.URL.Query()
-		startTime := query.Get("start_time")
-		endTime := query.Get("end_time")
+		query, err := searches.Expand(r.Context(), r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		service := query.Get("service")
+		filter, err := logquery.ParseQuery(query.Get("q"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		metadata, err := logquery.MetadataFilter(query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter = logquery.AndQuery(logquery.AndQuery(filter, metadata), logquery.SearchQuery(query.Get("search")))
+
+		// Without a start time (All Time), default to the last 30 days so the
+		// chart has data without aggregating years of it
+		endTime := time.Now()
+		if t := query.Get("end_time"); t != "" {
+			if endTime, err = time.Parse(time.RFC3339, t); err != nil {
+				http.Error(w, "invalid end_time", http.StatusBadRequest)
+				return
+			}
+		}
+		startTime := endTime.AddDate(0, 0, -30)
+		if t := query.Get("start_time"); t != "" {
+			if startTime, err = time.Parse(time.RFC3339, t); err != nil {
+				http.Error(w, "invalid start_time", http.StatusBadRequest)
+				return
+			}
+		}
+		params, err := logquery.ParseStatsParams(query, startTime, endTime)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-		// Aggregate logs by minute
-		sql := `
-			SELECT date_trunc('minute', timestamp) as minute, count(*) as count
+		// Count logs per bucket and group; BuildStats fills the empty buckets
+		cols, args := params.Columns(logquery.Postgres, 1)
+		argId := len(args) + 1
+		sql := fmt.Sprintf(`
+			SELECT %s, count(*) as count
 			FROM "Log"
-			WHERE 1=1
-		`
-		var args []interface{}
-		argId := 1
+			WHERE timestamp >= $%d AND timestamp <= $%d
+		`, cols, argId, argId+1)
+		// Timestamps are stored in UTC without a zone
+		args = append(args, startTime.UTC(), endTime.UTC())
+		argId += 2
 
-		if startTime != "" {
-			sql += fmt.Sprintf(" AND timestamp >= $%d", argId)
-			args = append(args, startTime)
+		if service != "" {
+			sql += fmt.Sprintf(" AND service = $%d", argId)
+			args = append(args, service)
 			argId++
-		} else {
-			// If no start time provided (All Time), default to last 30 days to ensure chart has data
-			// but doesn't try to aggregate years of data by minute
-			sql += " AND timestamp > NOW() - INTERVAL '30 days'"
 		}
-
-		if endTime != "" {
-			sql += fmt.Sprintf(" AND timestamp <= $%d", argId)
-			args = append(args, endTime)
-			argId++
+		sql, args, argId, err = levelQuery(sql, args, argId, query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
+		sql, args, argId = filterQuery(sql, args, argId, filter)
+		sql, args, argId = restrictQuery(sql, args, argId, key)
 
 		sql += `
-			GROUP BY minute
-			ORDER BY minute ASC
+			GROUP BY bucket, grp
+			ORDER BY bucket ASC
 		`
 		
 		log.Printf("Executing Stats Query: %s params: %v", sql, args)
 		
+		start := time.Now()
 		rows, err := pgProducer.db.Query(sql, args...)
+		metrics.ObserveQuery("stats", start, &err)
 		if err != nil {
 			log.Printf("Error querying stats: %v", err)
 			http.Error(w, "Failed to fetch stats", http.StatusInternalServerError)
@@ -207,63 +484,534 @@ func main() {
 		}
 		defer rows.Close()
 
-		type Stat struct {
-			Timestamp time.Time `json:"timestamp"`
-			Count     int       `json:"count"`
-		}
-		var stats []Stat
-
+		var counts []logquery.StatsRow
 		for rows.Next() {
-			var s Stat
-			if err := rows.Scan(&s.Timestamp, &s.Count); err != nil {
+			var c logquery.StatsRow
+			if err := rows.Scan(&c.Bucket, &c.Group, &c.Count); err != nil {
 				continue
 			}
-			stats = append(stats, s)
+			counts = append(counts, c)
+		}
+		stats := params.BuildStats(counts)
+
+		json.NewEncoder(w).Encode(params.Response(stats))
+	})))
+
+	http.HandleFunc("/aggregate", metrics.Instrument("/aggregate", cors.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		key, ok := readAuth.Authorize(w, r)
+		if !ok {
+			return
+		}
+
+		// Parse Query Params
+		query, err := searches.Expand(r.Context(), r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		service := query.Get("service")
+		filter, err := logquery.ParseQuery(query.Get("q"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		metadata, err := logquery.MetadataFilter(query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter = logquery.AndQuery(logquery.AndQuery(filter, metadata), logquery.SearchQuery(query.Get("search")))
+
+		// The same default range as /stats
+		endTime := time.Now()
+		if t := query.Get("end_time"); t != "" {
+			if endTime, err = time.Parse(time.RFC3339, t); err != nil {
+				http.Error(w, "invalid end_time", http.StatusBadRequest)
+				return
+			}
+		}
+		startTime := endTime.AddDate(0, 0, -30)
+		if t := query.Get("start_time"); t != "" {
+			if startTime, err = time.Parse(time.RFC3339, t); err != nil {
+				http.Error(w, "invalid start_time", http.StatusBadRequest)
+				return
+			}
+		}
+		params, err := logquery.ParseAggregateParams(query, startTime, endTime)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Timestamps are stored in UTC without a zone
+		where := " WHERE timestamp >= $1 AND timestamp <= $2"
+		args := []interface{}{startTime.UTC(), endTime.UTC()}
+		argId := 3
+
+		if service != "" {
+			where += fmt.Sprintf(" AND service = $%d", argId)
+			args = append(args, service)
+			argId++
+		}
+		cond, condArgs := params.Condition(logquery.Postgres, argId)
+		where += " AND " + cond
+		args = append(args, condArgs...)
+		argId += len(condArgs)
+		where, args, argId, err = levelQuery(where, args, argId, query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		where, args, argId = filterQuery(where, args, argId, filter)
+		where, args, argId = restrictQuery(where, args, argId, key)
+
+		cols, colArgs := params.Columns(logquery.Postgres, argId)
+		sql := `SELECT ` + cols + ` FROM "Log"` + where + ` GROUP BY bucket, grp ORDER BY bucket ASC`
+
+		start := time.Now()
+		res, err := func() (logquery.AggregateResult, error) {
+			rows, err := pgProducer.db.Query(sql, append(append([]interface{}{}, args...), colArgs...)...)
+			if err != nil {
+				return logquery.AggregateResult{}, err
+			}
+			defer rows.Close()
+
+			var aggs []logquery.AggregateRow
+			for rows.Next() {
+				var a logquery.AggregateRow
+				if err := rows.Scan(a.Dest(logquery.Postgres, len(params.Quantiles))...); err != nil {
+					return logquery.AggregateResult{}, err
+				}
+				aggs = append(aggs, a)
+			}
+			if err := rows.Err(); err != nil {
+				return logquery.AggregateResult{}, err
+			}
+			res := params.BuildAggregate(aggs)
+
+			lo, hi, ok := logquery.AggregateRange(aggs)
+			if params.Bins == 0 || !ok {
+				return res, nil
+			}
+			bin, binArgs := params.HistogramColumn(logquery.Postgres, argId, lo, hi)
+			binRows, err := pgProducer.db.Query(`SELECT `+bin+` AS bin, count(*) FROM "Log"`+where+` GROUP BY bin`, append(append([]interface{}{}, args...), binArgs...)...)
+			if err != nil {
+				return logquery.AggregateResult{}, err
+			}
+			defer binRows.Close()
+
+			counts := make(map[int64]uint64)
+			for binRows.Next() {
+				var n int64
+				var count uint64
+				if err := binRows.Scan(&n, &count); err != nil {
+					return logquery.AggregateResult{}, err
+				}
+				counts[n] = count
+			}
+			if err := binRows.Err(); err != nil {
+				return logquery.AggregateResult{}, err
+			}
+			params.BuildHistogram(&res, lo, hi, counts)
+			return res, nil
+		}()
+		metrics.ObserveQuery("aggregate", start, &err)
+		if err != nil {
+			log.Printf("Error querying aggregate: %v", err)
+			http.Error(w, "Failed to fetch aggregate", http.StatusInternalServerError)
+			return
 		}
 
-		if stats == nil {
-			stats = []Stat{}
+		json.NewEncoder(w).Encode(res)
+	})))
+
+	http.HandleFunc("/facets", metrics.Instrument("/facets", cors.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		key, ok := readAuth.Authorize(w, r)
+		if !ok {
+			return
 		}
 
-		json.NewEncoder(w).Encode(stats)
-	})
+		// Parse Query Params
+		query, err := searches.Expand(r.Context(), r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		service := query.Get("service")
+		startTime := query.Get("start_time")
+		endTime := query.Get("end_time")
+		filter, err := logquery.ParseQuery(query.Get("q"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		metadata, err := logquery.MetadataFilter(query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter = logquery.AndQuery(logquery.AndQuery(filter, metadata), logquery.SearchQuery(query.Get("search")))
+		params, err := logquery.ParseFacetParams(query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// The same conditions as /logs, shared by the query for each field
+		where := " WHERE 1=1"
+		var args []interface{}
+		argId := 1
+
+		if service != "" {
+			where += fmt.Sprintf(" AND service = $%d", argId)
+			args = append(args, service)
+			argId++
+		}
+		if startTime != "" {
+			where += fmt.Sprintf(" AND timestamp >= $%d", argId)
+			args = append(args, startTime)
+			argId++
+		}
+		if endTime != "" {
+			where += fmt.Sprintf(" AND timestamp <= $%d", argId)
+			args = append(args, endTime)
+			argId++
+		}
+		where, args, argId, err = levelQuery(where, args, argId, query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		where, args, argId = filterQuery(where, args, argId, filter)
+		where, args, argId = restrictQuery(where, args, argId, key)
+
+		// Postgres has no approximate top values, so count exactly: the
+		// window functions give the number of groups and of logs before
+		// LIMIT applies.
+		facets := make([]logquery.Facet, len(params.Fields))
+		start := time.Now()
+		for i, field := range params.Fields {
+			facets[i] = logquery.Facet{Field: field, Values: []logquery.FacetValue{}}
+
+			value, has, fieldArgs := logquery.FacetColumn(logquery.Postgres, argId, field)
+			stmt := fmt.Sprintf(`
+				SELECT %s AS value, count(*) AS n, count(*) OVER (), sum(count(*)) OVER ()::bigint
+				FROM "Log"
+			`, value) + where
+			if has != "" {
+				stmt += " AND " + has
+			}
+			stmt += fmt.Sprintf(" GROUP BY value ORDER BY n DESC, value LIMIT %d", params.Top)
+
+			rows, queryErr := pgProducer.db.Query(stmt, append(append([]interface{}{}, args...), fieldArgs...)...)
+			if err = queryErr; err != nil {
+				break
+			}
+			for rows.Next() {
+				var v logquery.FacetValue
+				if err = rows.Scan(&v.Value, &v.Count, &facets[i].Cardinality, &facets[i].Count); err != nil {
+					break
+				}
+				v.Value = logquery.FacetLabel(field, v.Value)
+				facets[i].Values = append(facets[i].Values, v)
+			}
+			if err == nil {
+				err = rows.Err()
+			}
+			rows.Close()
+			if err != nil {
+				break
+			}
+		}
+		metrics.ObserveQuery("facets", start, &err)
+		if err != nil {
+			log.Printf("Error querying facets: %v", err)
+			http.Error(w, "Failed to fetch facets", http.StatusInternalServerError)
+			return
+		}
 
-	http.HandleFunc("/ws", handleWebSocket)
+		json.NewEncoder(w).Encode(facets)
+	})))
+
+	http.HandleFunc("/export", metrics.Instrument("/export", cors.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		key, ok := readAuth.Authorize(w, r)
+		if !ok {
+			return
+		}
+
+		// Parse Query Params
+		query, err := searches.Expand(r.Context(), r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		service := query.Get("service")
+		startTime := query.Get("start_time")
+		endTime := query.Get("end_time")
+		filter, err := logquery.ParseQuery(query.Get("q"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		metadata, err := logquery.MetadataFilter(query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter = logquery.AndQuery(logquery.AndQuery(filter, metadata), logquery.SearchQuery(query.Get("search")))
+		format, err := logquery.ParseExportFormat(query.Get("format"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// The same conditions as /logs, oldest first
+		stmt := `SELECT id, timestamp, service, level, COALESCE("rawLevel", level), severity, message, metadata FROM "Log" WHERE 1=1`
+		var args []interface{}
+		argId := 1
+
+		if service != "" {
+			stmt += fmt.Sprintf(" AND service = $%d", argId)
+			args = append(args, service)
+			argId++
+		}
+		if startTime != "" {
+			stmt += fmt.Sprintf(" AND timestamp >= $%d", argId)
+			args = append(args, startTime)
+			argId++
+		}
+		if endTime != "" {
+			stmt += fmt.Sprintf(" AND timestamp <= $%d", argId)
+			args = append(args, endTime)
+			argId++
+		}
+		stmt, args, argId, err = levelQuery(stmt, args, argId, query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		stmt, args, argId = filterQuery(stmt, args, argId, filter)
+		stmt, args, argId = restrictQuery(stmt, args, argId, key)
+
+		// One row past the cap tells whether the export was truncated
+		limit := cfg.Export.RowCap(key)
+		stmt += fmt.Sprintf(" ORDER BY timestamp ASC, id ASC LIMIT $%d", argId)
+		args = append(args, limit+1)
+
+		start := time.Now()
+		err = logquery.Export(w, format, limit, func(write func(logs.Entry) error) error {
+			// A server-side cursor hands the rows over in batches rather
+			// than all at once. Cursors only live inside a transaction.
+			ctx := r.Context()
+			tx, err := pgProducer.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback()
+
+			if _, err := tx.ExecContext(ctx, "DECLARE export_cursor NO SCROLL CURSOR FOR "+stmt, args...); err != nil {
+				return err
+			}
+			for {
+				rows, err := tx.QueryContext(ctx, fmt.Sprintf("FETCH %d FROM export_cursor", logquery.ExportFlushRows))
+				if err != nil {
+					return err
+				}
+				n := 0
+				for rows.Next() {
+					var l logs.Entry
+					var metadataBytes []byte
+					if err := rows.Scan(&l.ID, &l.Timestamp, &l.Service, &l.Level, &l.RawLevel, &l.Severity, &l.Message, &metadataBytes); err != nil {
+						rows.Close()
+						return err
+					}
+					if len(metadataBytes) > 0 {
+						json.Unmarshal(metadataBytes, &l.Metadata)
+					}
+					if err := write(l); err != nil {
+						rows.Close()
+						return err
+					}
+					n++
+				}
+				if err := rows.Err(); err != nil {
+					return err
+				}
+				rows.Close()
+				if n < logquery.ExportFlushRows {
+					return nil
+				}
+			}
+		})
+		metrics.ObserveQuery("export", start, &err)
+		if err != nil {
+			// The status is already sent, so the client sees a cut-off body
+			log.Printf("Export failed: %v", err)
+		}
+	})))
+
+	http.HandleFunc("/usage", metrics.Instrument("/usage", cors.Wrap(ingest.NewUsageHandler(pgProducer.db, readAuth).ServeHTTP)))
+	http.HandleFunc("/searches", metrics.Instrument("/searches", cors.Wrap(searches.ServeHTTP)))
+	http.HandleFunc("/searches/", metrics.Instrument("/searches/id", cors.Wrap(searches.ServeHTTP)))
+	http.HandleFunc("/ws", metrics.Instrument("/ws", func(w http.ResponseWriter, r *http.Request) {
+		if key, ok := readAuth.Authorize(w, r); ok {
+			handleWebSocket(w, r, key)
+		}
+	}))
+	http.Handle("/metrics", promhttp.Handler())
+
+	probes := health.New(cfg.Health.Timeout,
+		health.Check{Name: "postgres", Critical: true, Check: pgProducer.Ping},
+	)
+	http.HandleFunc("/livez", probes.Livez)
+	http.HandleFunc("/readyz", probes.Readyz)
 
 	// Start Server
-	log.Printf("Listening on :%s", port)
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
+	log.Printf("Listening on :%s", cfg.Server.Port)
+	server := &http.Server{Addr: ":" + cfg.Server.Port}
+	if err := serve.ListenAndServe(server, cfg.TLS); err != nil {
 		log.Fatal(err)
 	}
 }
 
-// Simple WebSocket Hub for Lite Mode
-var clients = make(map[*websocket.Conn]bool)
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true },
+// filterQuery adds the conditions of the q search query.
+func filterQuery(sql string, args []interface{}, argId int, filter logquery.QueryExpr) (string, []interface{}, int) {
+	if filter == nil {
+		return sql, args, argId
+	}
+	cond, condArgs := logquery.CompileQuery(filter, logquery.Postgres, argId)
+	return sql + " AND " + cond, append(args, condArgs...), argId + len(condArgs)
+}
+
+// restrictQuery limits a query to the services and levels key may read.
+func restrictQuery(sql string, args []interface{}, argId int, key *auth.ApiKey) (string, []interface{}, int) {
+	if key == nil {
+		return sql, args, argId
+	}
+	if len(key.Services) > 0 {
+		sql += fmt.Sprintf(" AND service = ANY($%d)", argId)
+		args = append(args, key.Services)
+		argId++
+	}
+	if len(key.Levels) > 0 {
+		sql += fmt.Sprintf(" AND severity = ANY($%d)", argId)
+		args = append(args, key.Severities())
+		argId++
+	}
+	return sql, args, argId
+}
+
+// levelQuery adds the level (a comma-separated list) and min_level
+// parameters. Both take level names or severity numbers.
+func levelQuery(sql string, args []interface{}, argId int, query url.Values) (string, []interface{}, int, error) {
+	if l := query.Get("level"); l != "" {
+		var severities []int
+		for _, name := range strings.Split(l, ",") {
+			severity, ok := logs.ParseSeverity(name)
+			if !ok {
+				return "", nil, 0, fmt.Errorf("unknown level %q", name)
+			}
+			severities = append(severities, severity)
+		}
+		sql += fmt.Sprintf(" AND severity = ANY($%d)", argId)
+		args = append(args, severities)
+		argId++
+	}
+	if l := query.Get("min_level"); l != "" {
+		severity, ok := logs.ParseSeverity(l)
+		if !ok {
+			return "", nil, 0, fmt.Errorf("unknown level %q", l)
+		}
+		sql += fmt.Sprintf(" AND severity >= $%d", argId)
+		args = append(args, severity)
+		argId++
+	}
+	return sql, args, argId, nil
+}
+
+// Simple WebSocket Hub for Lite Mode. Each client has the key it connected
+// with, nil when it connected without one, and a queue drained by its own
+// writer, since a connection allows only one writer at a time.
+type wsClient struct {
+	key  *auth.ApiKey
+	send chan []byte
 }
 
-func handleWebSocket(w http.ResponseWriter, r *http.Request) {
+var (
+	clientsMu sync.Mutex
+	clients   = make(map[*wsClient]struct{})
+)
+
+// wsQueue is how many messages a client may fall behind by before it is
+// disconnected, and wsWriteWait how long one write may take.
+const (
+	wsQueue     = 256
+	wsWriteWait = 10 * time.Second
+)
+
+// CheckOrigin is set from the CORS allowlist at startup.
+var upgrader = websocket.Upgrader{}
+
+func handleWebSocket(w http.ResponseWriter, r *http.Request, key *auth.ApiKey) {
 	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println(err)
 		return
 	}
 	defer ws.Close()
-	clients[ws] = true
-	
+	client := &wsClient{key: key, send: make(chan []byte, wsQueue)}
+	clientsMu.Lock()
+	clients[client] = struct{}{}
+	clientsMu.Unlock()
+	metrics.WebsocketClients.Inc()
+	defer metrics.WebsocketClients.Dec()
+
+	go func() {
+		// Closing the connection ends the read loop below.
+		defer ws.Close()
+		for msg := range client.send {
+			ws.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := ws.WriteMessage(websocket.TextMessage, msg); err != nil {
+				return
+			}
+		}
+	}()
+
 	for {
-		_, _, err := ws.ReadMessage()
-		if err != nil {
-			delete(clients, ws)
+		if _, _, err := ws.ReadMessage(); err != nil {
 			break
 		}
 	}
+	clientsMu.Lock()
+	removeClient(client)
+	clientsMu.Unlock()
 }
 
-func broadcastLog(entry LogEntry) {
-	msg, _ := json.Marshal([]LogEntry{entry})
+// removeClient stops the client's writer. clientsMu must be held.
+func removeClient(client *wsClient) {
+	if _, ok := clients[client]; ok {
+		delete(clients, client)
+		close(client.send)
+	}
+}
+
+func broadcastLog(entry logs.Entry) {
+	msg, _ := json.Marshal([]logs.Entry{entry})
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
 	for client := range clients {
-		client.WriteMessage(websocket.TextMessage, msg)
+		if client.key != nil && !client.key.Allows(entry.Service, entry.Level) {
+			continue
+		}
+		select {
+		case client.send <- msg:
+		default:
+			// Too slow to keep up: drop it rather than block ingest.
+			removeClient(client)
+		}
 	}
 }
@@ -1,12 +1,13 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"strings"
-	"time"
 
+	"github.com/davidojo1144/LogStream/shared/logs"
 	_ "github.com/jackc/pgx/v5/stdlib"
 )
 
@@ -39,21 +40,24 @@ func NewPostgresProducer(connStr string) (*PostgresProducer, error) {
 	return &PostgresProducer{db: db}, nil
 }
 
-func (p *PostgresProducer) WriteLog(entry LogEntry) error {
+func (p *PostgresProducer) WriteLog(entry logs.Entry) error {
 	metadataJson, err := json.Marshal(entry.Metadata)
 	if err != nil {
 		return fmt.Errorf("failed to marshal metadata: %w", err)
 	}
 
 	query := `
-		INSERT INTO "Log" (id, timestamp, service, level, message, metadata, "createdAt")
-		VALUES ($1, $2, $3, $4, $5, $6, NOW())
+		INSERT INTO "Log" (id, timestamp, service, level, "rawLevel", severity, message, metadata, "createdAt")
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW())
 	`
 	
-	// Generate a simple timestamp-based ID for lite mode
-	id := fmt.Sprintf("log_%d", time.Now().UnixNano())
+	// The handler assigns IDs at ingest; entries from elsewhere get one here.
+	id := entry.ID
+	if id == "" {
+		id = logs.NewID(entry.Timestamp)
+	}
 
-	_, err = p.db.Exec(query, id, entry.Timestamp, entry.Service, entry.Level, entry.Message, string(metadataJson))
+	_, err = p.db.Exec(query, id, entry.Timestamp, entry.Service, entry.Level, entry.RawLevel, entry.Severity, entry.Message, string(metadataJson))
 	if err != nil {
 		// Enhanced Error Logging
 		return fmt.Errorf("failed to insert log to postgres. Query: %s, Error: %w", query, err)
@@ -62,6 +66,10 @@ func (p *PostgresProducer) WriteLog(entry LogEntry) error {
 	return nil
 }
 
+func (p *PostgresProducer) Ping(ctx context.Context) error {
+	return p.db.PingContext(ctx)
+}
+
 func (p *PostgresProducer) Close() error {
 	return p.db.Close()
 }
@@ -10,3 +10,153 @@ CREATE UNIQUE INDEX IF NOT EXISTS "VerificationToken_token_key" ON "Verification
 
 -- Create composite unique index on identifier + token
 CREATE UNIQUE INDEX IF NOT EXISTS "VerificationToken_identifier_token_key" ON "VerificationToken"("identifier", "token");
+
+-- Allow a client certificate subject to authenticate as an API key over mTLS
+ALTER TABLE "ApiKey" ADD COLUMN IF NOT EXISTS "certSubject" TEXT;
+CREATE UNIQUE INDEX IF NOT EXISTS "ApiKey_certSubject_key" ON "ApiKey"("certSubject");
+
+-- Tell collectors when an API key changes so cached validations are dropped
+CREATE OR REPLACE FUNCTION notify_api_key_changed() RETURNS trigger AS $$
+BEGIN
+  PERFORM pg_notify('api_key_changed', COALESCE(NEW.id, OLD.id));
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS "ApiKey_notify_changed" ON "ApiKey";
+CREATE TRIGGER "ApiKey_notify_changed"
+  AFTER INSERT OR UPDATE OR DELETE ON "ApiKey"
+  FOR EACH ROW EXECUTE FUNCTION notify_api_key_changed();
+
+-- Store API keys as a salted SHA-256 hash, found by the visible prefix of
+-- pk_<prefix>_<secret>. "key" only remains for keys not migrated yet.
+ALTER TABLE "ApiKey" ADD COLUMN IF NOT EXISTS "prefix" TEXT;
+ALTER TABLE "ApiKey" ADD COLUMN IF NOT EXISTS "keyHash" TEXT;
+ALTER TABLE "ApiKey" ALTER COLUMN "key" DROP NOT NULL;
+CREATE INDEX IF NOT EXISTS "ApiKey_prefix_idx" ON "ApiKey"("prefix");
+
+-- Hash existing plaintext keys (pk_<32 hex>, prefixed by their first 8 hex
+-- digits). Collectors also do this on first use while
+-- auth.allow_plaintext_keys is on, so running it is optional.
+CREATE EXTENSION IF NOT EXISTS pgcrypto;
+UPDATE "ApiKey" SET
+  "prefix" = substr(s."key", 4, 8),
+  "keyHash" = 'sha256:' || s.salt || ':' || encode(digest(s.salt || s."key", 'sha256'), 'hex'),
+  "key" = NULL
+FROM (
+  SELECT id, "key", encode(gen_random_bytes(16), 'hex') AS salt
+  FROM "ApiKey"
+  WHERE "keyHash" IS NULL AND "key" IS NOT NULL
+) s
+WHERE "ApiKey".id = s.id;
+
+-- Scope API keys to ingest, read and/or admin, optionally restricted to some
+-- services and levels (empty arrays mean unrestricted). Existing keys keep
+-- the ingest-only behaviour they had.
+ALTER TABLE "ApiKey" ADD COLUMN IF NOT EXISTS "scopes" TEXT[] NOT NULL DEFAULT ARRAY['ingest']::TEXT[];
+ALTER TABLE "ApiKey" ADD COLUMN IF NOT EXISTS "services" TEXT[] NOT NULL DEFAULT ARRAY[]::TEXT[];
+ALTER TABLE "ApiKey" ADD COLUMN IF NOT EXISTS "levels" TEXT[] NOT NULL DEFAULT ARRAY[]::TEXT[];
+
+-- Key expiry, rotation and last-used tracking. A rotated key points at its
+-- successor and keeps working until its expiresAt, the end of the grace period.
+ALTER TABLE "ApiKey" ADD COLUMN IF NOT EXISTS "expiresAt" TIMESTAMP(3);
+ALTER TABLE "ApiKey" ADD COLUMN IF NOT EXISTS "successorId" TEXT;
+ALTER TABLE "ApiKey" ADD COLUMN IF NOT EXISTS "lastUsedAt" TIMESTAMP(3);
+ALTER TABLE "ApiKey" ADD COLUMN IF NOT EXISTS "lastUsedIp" TEXT;
+ALTER TABLE "ApiKey" ADD COLUMN IF NOT EXISTS "requestCount" BIGINT NOT NULL DEFAULT 0;
+
+-- Usage is written back every few seconds; only notify collectors when
+-- something other than the usage columns changed.
+CREATE OR REPLACE FUNCTION notify_api_key_changed() RETURNS trigger AS $$
+BEGIN
+  IF TG_OP = 'UPDATE' AND
+     to_jsonb(NEW) - ARRAY['lastUsedAt', 'lastUsedIp', 'requestCount'] =
+     to_jsonb(OLD) - ARRAY['lastUsedAt', 'lastUsedIp', 'requestCount'] THEN
+    RETURN NULL;
+  END IF;
+  PERFORM pg_notify('api_key_changed', COALESCE(NEW.id, OLD.id));
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Per-key ingest limits. Null rate limits use the server defaults
+-- (LIMIT_ENTRIES_PER_SECOND, LIMIT_BYTES_PER_SECOND); a null quota means
+-- unlimited. Daily entry counts are shared between servers here.
+ALTER TABLE "ApiKey" ADD COLUMN IF NOT EXISTS "rateLimitEntries" DOUBLE PRECISION;
+ALTER TABLE "ApiKey" ADD COLUMN IF NOT EXISTS "rateLimitBytes" DOUBLE PRECISION;
+ALTER TABLE "ApiKey" ADD COLUMN IF NOT EXISTS "dailyQuota" BIGINT;
+
+CREATE TABLE IF NOT EXISTS "ApiKeyDailyUsage" (
+    "apiKeyId" TEXT NOT NULL,
+    "day" DATE NOT NULL,
+    "entries" BIGINT NOT NULL DEFAULT 0,
+    CONSTRAINT "ApiKeyDailyUsage_pkey" PRIMARY KEY ("apiKeyId", "day")
+);
+
+-- Ingested volume per API key, service and UTC day, for billing teams.
+CREATE TABLE IF NOT EXISTS "IngestUsage" (
+    "apiKeyId" TEXT NOT NULL,
+    "service" TEXT NOT NULL,
+    "day" DATE NOT NULL,
+    "entries" BIGINT NOT NULL DEFAULT 0,
+    "bytes" BIGINT NOT NULL DEFAULT 0,
+    CONSTRAINT "IngestUsage_pkey" PRIMARY KEY ("apiKeyId", "service", "day")
+);
+CREATE INDEX IF NOT EXISTS "IngestUsage_day_idx" ON "IngestUsage"("day");
+
+-- GIN index for metadata filters in lite mode (metadata @> ... and metadata ? key).
+CREATE INDEX IF NOT EXISTS "Log_metadata_idx" ON "Log" USING GIN ("metadata");
+
+-- Normalized levels: level becomes the canonical name, "rawLevel" keeps what
+-- the client sent and severity is the OpenTelemetry severity number. The
+-- level names match levelAliases in shared/logs/level.go.
+ALTER TABLE "Log" ADD COLUMN IF NOT EXISTS "rawLevel" TEXT;
+ALTER TABLE "Log" ADD COLUMN IF NOT EXISTS "severity" INTEGER NOT NULL DEFAULT 9;
+
+UPDATE "Log" SET
+    "rawLevel" = level,
+    severity = CASE
+        WHEN lower(trim(level)) IN ('trace', 'verbose') THEN 1
+        WHEN lower(trim(level)) IN ('debug', 'dbg') THEN 5
+        WHEN lower(trim(level)) IN ('warn', 'warning') THEN 13
+        WHEN lower(trim(level)) IN ('error', 'err') THEN 17
+        WHEN lower(trim(level)) IN ('fatal', 'critical', 'crit', 'panic', 'alert', 'emerg', 'emergency') THEN 21
+        ELSE 9
+    END,
+    level = CASE
+        WHEN lower(trim(level)) IN ('trace', 'verbose') THEN 'trace'
+        WHEN lower(trim(level)) IN ('debug', 'dbg') THEN 'debug'
+        WHEN lower(trim(level)) IN ('warn', 'warning') THEN 'warn'
+        WHEN lower(trim(level)) IN ('error', 'err') THEN 'error'
+        WHEN lower(trim(level)) IN ('fatal', 'critical', 'crit', 'panic', 'alert', 'emerg', 'emergency') THEN 'fatal'
+        ELSE 'info'
+    END
+WHERE "rawLevel" IS NULL;
+
+CREATE INDEX IF NOT EXISTS "Log_severity_idx" ON "Log"("severity");
+
+-- Full-text search on message in lite mode (see messageVector in
+-- shared/logquery/fulltext.go). Prisma cannot express this expression index,
+-- so it only lives here. Punctuation is replaced by spaces so that hostnames,
+-- paths and URLs are indexed word by word. "Log_message_fts_idx" indexed the
+-- raw message and is no longer used.
+DROP INDEX IF EXISTS "Log_message_fts_idx";
+CREATE INDEX IF NOT EXISTS "Log_message_words_idx" ON "Log" USING GIN (to_tsvector('simple', regexp_replace(message, '[^0-9A-Za-z\u0080-\U0010FFFF]+', ' ', 'g')));
+
+-- Saved searches, shared by everyone who reads logs and run by ID with
+-- /logs?saved=<id>. A range is either relative ("rangeLast", such as 15m or
+-- 7d) or absolute ("rangeStart" to "rangeEnd").
+CREATE TABLE IF NOT EXISTS "SavedSearch" (
+    "id" TEXT NOT NULL,
+    "name" TEXT NOT NULL,
+    "query" TEXT NOT NULL DEFAULT '',
+    "rangeLast" TEXT,
+    "rangeStart" TIMESTAMP(3),
+    "rangeEnd" TIMESTAMP(3),
+    "columns" TEXT[] NOT NULL DEFAULT ARRAY[]::TEXT[],
+    "ownerId" TEXT NOT NULL,
+    "createdAt" TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "updatedAt" TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT "SavedSearch_pkey" PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "SavedSearch_ownerId_idx" ON "SavedSearch"("ownerId");
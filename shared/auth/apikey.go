@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/davidojo1144/LogStream/shared/logs"
+)
+
+// API keys are issued as pk_<prefix>_<secret>. The prefix is stored in the
+// clear to find the row; only a salted SHA-256 of the whole key is kept, in
+// "keyHash" as sha256:<salt hex>:<digest hex>. The same format is produced by
+// the web app (web/src/app/api/keys/route.ts) and by migration.sql for keys
+// issued before hashing, which look like pk_<32 hex> and use their first
+// eight hex digits as prefix.
+const apiKeyScheme = "pk_"
+
+// keyPrefix extracts the lookup prefix from a presented key.
+func keyPrefix(key string) (string, bool) {
+	rest, ok := strings.CutPrefix(key, apiKeyScheme)
+	if !ok || len(rest) < 8 {
+		return "", false
+	}
+	if i := strings.IndexByte(rest, '_'); i >= 0 {
+		return rest[:i], i > 0 && i < len(rest)-1
+	}
+	return rest[:8], true
+}
+
+func hashApiKey(key string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	saltHex := hex.EncodeToString(salt)
+	return "sha256:" + saltHex + ":" + digestApiKey(saltHex, key), nil
+}
+
+func digestApiKey(saltHex, key string) string {
+	sum := sha256.Sum256([]byte(saltHex + key))
+	return hex.EncodeToString(sum[:])
+}
+
+// verifyApiKey reports whether key matches a stored hash, in constant time.
+func verifyApiKey(stored, key string) bool {
+	parts := strings.Split(stored, ":")
+	if len(parts) != 3 || parts[0] != "sha256" {
+		return false
+	}
+	want := digestApiKey(parts[1], key)
+	return subtle.ConstantTimeCompare([]byte(want), []byte(parts[2])) == 1
+}
+
+// cacheKeyFor keeps plaintext keys out of the validation cache.
+func cacheKeyFor(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "key:" + hex.EncodeToString(sum[:])
+}
+
+// Scopes an API key can be granted. admin implies every other scope.
+const (
+	ScopeIngest = "ingest"
+	ScopeRead   = "read"
+	ScopeAdmin  = "admin"
+)
+
+// HasScope reports whether the key may perform actions requiring scope.
+func (k *ApiKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// Expired reports whether the key's expiry, if any, has passed.
+func (k *ApiKey) Expired() bool {
+	return !k.ExpiresAt.IsZero() && time.Now().After(k.ExpiresAt)
+}
+
+// Allows reports whether the key may write or read logs of the given service
+// and level. Levels are compared by severity, so a key limited to "error"
+// also covers "ERR". An empty restriction list allows everything.
+func (k *ApiKey) Allows(service, level string) bool {
+	if len(k.Services) > 0 && !slices.Contains(k.Services, service) {
+		return false
+	}
+	if len(k.Levels) == 0 {
+		return true
+	}
+	_, severity := logs.NormalizeLevel(level)
+	return slices.Contains(k.Severities(), severity)
+}
+
+// Severities lists the severities of the key's level restriction. Unknown
+// names, which the web app refuses, become severity 0, which no log has, so
+// they match nothing instead of counting as info.
+func (k *ApiKey) Severities() []int {
+	severities := make([]int, len(k.Levels))
+	for i, l := range k.Levels {
+		if severity, ok := logs.ParseSeverity(l); ok {
+			severities[i] = severity
+		}
+	}
+	return severities
+}
@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestKeyPrefix(t *testing.T) {
+	tests := []struct {
+		key    string
+		prefix string
+		ok     bool
+	}{
+		{"pk_ab12cd34_s3cr3t", "ab12cd34", true},
+		{"pk_0123456789abcdef0123456789abcdef", "01234567", true}, // issued before hashing
+		{"pk_ab12cd34_", "ab12cd34", false},
+		{"pk__abcdefgh", "", false},
+		{"pk_short", "", false},
+		{"sk_ab12cd34_s3cr3t", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		prefix, ok := keyPrefix(tt.key)
+		if ok != tt.ok || (ok && prefix != tt.prefix) {
+			t.Errorf("keyPrefix(%q) = %q, %v, want %q, %v", tt.key, prefix, ok, tt.prefix, tt.ok)
+		}
+	}
+}
+
+func TestVerifyApiKey(t *testing.T) {
+	key := "pk_ab12cd34_s3cr3t"
+
+	// The format migration.sql and the web app store.
+	stored := "sha256:00112233445566778899aabbccddeeff:71f53528b9f136d8d5ea79c7faf14e3607c1f9e95e611684fd94d8670d270c02"
+	if !verifyApiKey(stored, key) {
+		t.Errorf("verifyApiKey rejected the key against %q", stored)
+	}
+
+	hash, err := hashApiKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verifyApiKey(hash, key) {
+		t.Errorf("verifyApiKey rejected the key against its own hash %q", hash)
+	}
+	if again, _ := hashApiKey(key); again == hash {
+		t.Errorf("hashApiKey gave %q twice, want a fresh salt", hash)
+	}
+
+	for _, bad := range []string{
+		strings.Replace(hash, "sha256:", "md5:", 1),
+		hash[:len(hash)-1] + "0",
+		"sha256:" + strings.TrimPrefix(hash, "sha256:")[:32],
+		"",
+	} {
+		if bad != hash && verifyApiKey(bad, key) {
+			t.Errorf("verifyApiKey accepted %q", bad)
+		}
+	}
+	if verifyApiKey(hash, key+"x") {
+		t.Error("verifyApiKey accepted another key")
+	}
+}
+
+func TestCacheKeyFor(t *testing.T) {
+	key := "pk_ab12cd34_s3cr3t"
+	if c := cacheKeyFor(key); strings.Contains(c, "s3cr3t") || c != cacheKeyFor(key) || c == cacheKeyFor(key+"x") {
+		t.Errorf("cacheKeyFor(%q) = %q", key, c)
+	}
+}
+
+func TestSeveritiesOfUnknownLevels(t *testing.T) {
+	k := &ApiKey{Levels: []string{"WARN", "verbose-ish"}}
+	got := k.Severities()
+	if len(got) != 2 || got[0] == 0 || got[1] != 0 {
+		t.Fatalf("Severities() = %v, want warn's severity and 0 for the unknown name", got)
+	}
+
+	// A key restricted only to unknown names lets nothing through, rather
+	// than falling back to info or to no restriction.
+	k = &ApiKey{Levels: []string{"loud"}}
+	if k.Allows("checkout", "info") || k.Allows("checkout", "error") {
+		t.Error("a key restricted to an unknown level allowed a known one")
+	}
+}
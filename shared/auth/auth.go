@@ -0,0 +1,270 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// apiKeyChannel is the Postgres NOTIFY channel the "ApiKey" trigger in
+// migration.sql publishes changed key IDs on.
+const apiKeyChannel = "api_key_changed"
+
+// ApiKey is the identity an authenticated request acts as.
+type ApiKey struct {
+	ID     string
+	UserID string
+	Scopes []string
+	// Services and Levels restrict which logs the key may touch; empty
+	// means unrestricted.
+	Services []string
+	Levels   []string
+	// ExpiresAt is zero for keys that never expire.
+	ExpiresAt time.Time
+	// Per-key overrides of the ingest rate limits, zero to use the
+	// defaults, and the daily entry quota, zero for none.
+	RateLimitEntries float64
+	RateLimitBytes   float64
+	DailyQuota       int64
+}
+
+// apiKeyColumns are the columns scanApiKey reads.
+const apiKeyColumns = `id, "userId", active, scopes, services, levels, "expiresAt", "rateLimitEntries", "rateLimitBytes", "dailyQuota"`
+
+type Validator struct {
+	db             *sql.DB
+	cache          *keyCache
+	cancel         context.CancelFunc
+	allowPlaintext bool
+	usage          *usageTracker
+}
+
+func NewValidator(connStr string, cfg Config) (*Validator, error) {
+	// Force simple protocol for Supabase Transaction Mode compatibility
+	if !strings.Contains(connStr, "default_query_exec_mode") {
+		if strings.Contains(connStr, "?") {
+			connStr += "&default_query_exec_mode=simple_protocol"
+		} else {
+			connStr += "?default_query_exec_mode=simple_protocol"
+		}
+	}
+
+	db, err := sql.Open("pgx", connStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to postgres: %w", err)
+	}
+
+	if err := db.Ping(); err != nil {
+		return nil, fmt.Errorf("failed to ping postgres: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	v := &Validator{
+		db:             db,
+		cache:          newKeyCache(cfg.CacheTTL, cfg.NegativeCacheTTL, cfg.StaleTTL),
+		cancel:         cancel,
+		allowPlaintext: cfg.AllowPlaintextKeys,
+		usage:          newUsageTracker(),
+	}
+	go v.flushUsage(cfg.UsageFlushInterval)
+	// Supabase's transaction pooler does not support LISTEN; point
+	// AUTH_LISTEN at false there and rely on the cache TTL.
+	if cfg.Listen {
+		go v.listen(ctx, connStr)
+	}
+	return v, nil
+}
+
+// listen subscribes to key change notifications so revocations take effect
+// immediately instead of after the cache TTL. It reconnects until ctx ends.
+func (v *Validator) listen(ctx context.Context, connStr string) {
+	backoff := time.Second
+	for ctx.Err() == nil {
+		err := v.listenOnce(ctx, connStr)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("API key change listener: %v (retrying in %s)", err, backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff < time.Minute {
+			backoff *= 2
+		}
+	}
+}
+
+func (v *Validator) listenOnce(ctx context.Context, connStr string) error {
+	conn, err := pgx.Connect(ctx, connStr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+apiKeyChannel); err != nil {
+		return err
+	}
+	// Notifications sent while disconnected are lost.
+	v.cache.flush()
+
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		v.cache.invalidate(n.Payload)
+	}
+}
+
+// Validate returns the active key for an Authorization header value, or nil.
+func (v *Validator) Validate(key string) *ApiKey {
+	// Remove "Bearer " prefix if present
+	cleanKey := strings.TrimPrefix(key, "Bearer ")
+
+	prefix, ok := keyPrefix(cleanKey)
+	if !ok {
+		return nil
+	}
+
+	// Check if key exists and is active
+	apiKey, err := v.cache.resolve(cacheKeyFor(cleanKey), func() (*ApiKey, error) {
+		return v.lookupKey(prefix, cleanKey)
+	})
+	if err != nil {
+		log.Printf("Database error validating key: %v", err)
+		return nil
+	}
+	if apiKey != nil && apiKey.Expired() {
+		return nil
+	}
+
+	return apiKey
+}
+
+// ValidateCertSubject returns the active API key a client certificate
+// subject (for example "CN=billing-worker,O=Acme") is linked to, or nil.
+func (v *Validator) ValidateCertSubject(subject string) *ApiKey {
+	apiKey, err := v.cache.resolve("cert:"+subject, func() (*ApiKey, error) {
+		return v.lookup(`"certSubject" = $1`, subject)
+	})
+	if err != nil {
+		log.Printf("Database error validating certificate subject: %v", err)
+		return nil
+	}
+	if apiKey != nil && apiKey.Expired() {
+		return nil
+	}
+
+	return apiKey
+}
+
+// lookupKey finds the hashed key matching a presented key. Several keys can
+// share a prefix, so every candidate is checked.
+func (v *Validator) lookupKey(prefix, key string) (*ApiKey, error) {
+	rows, err := v.db.Query(`SELECT `+apiKeyColumns+`, "keyHash" FROM "ApiKey" WHERE prefix = $1 AND "keyHash" IS NOT NULL`, prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var hash string
+		k, active, err := scanApiKey(rows, &hash)
+		if err != nil {
+			return nil, err
+		}
+		if verifyApiKey(hash, key) {
+			if !active {
+				return nil, nil
+			}
+			return k, nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if !v.allowPlaintext {
+		return nil, nil
+	}
+	k, err := v.lookup(`key = $1 AND "keyHash" IS NULL`, key)
+	if k != nil {
+		v.upgrade(k.ID, prefix, key)
+	}
+	return k, err
+}
+
+// upgrade replaces a plaintext key with its hash. Failing here only means the
+// key stays in plaintext until its next use.
+func (v *Validator) upgrade(id, prefix, key string) {
+	hash, err := hashApiKey(key)
+	if err != nil {
+		log.Printf("Failed to hash API key %s: %v", id, err)
+		return
+	}
+	_, err = v.db.Exec(`UPDATE "ApiKey" SET prefix = $1, "keyHash" = $2, key = NULL WHERE id = $3 AND "keyHash" IS NULL`, prefix, hash, id)
+	if err != nil {
+		log.Printf("Failed to migrate API key %s to hashed storage: %v", id, err)
+	}
+}
+
+// lookup fetches the key matching a WHERE clause with one argument,
+// returning nil for unknown or inactive keys.
+func (v *Validator) lookup(where string, arg string) (*ApiKey, error) {
+	k, active, err := scanApiKey(v.db.QueryRow(`SELECT `+apiKeyColumns+` FROM "ApiKey" WHERE `+where, arg))
+
+	if err == sql.ErrNoRows || (err == nil && !active) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// scanApiKey reads apiKeyColumns followed by any extra columns.
+func scanApiKey(row interface{ Scan(...any) error }, extra ...any) (*ApiKey, bool, error) {
+	var k ApiKey
+	var active bool
+	var expiresAt sql.NullTime
+	var entryRate, byteRate sql.NullFloat64
+	var quota sql.NullInt64
+	// database/sql cannot scan arrays itself. A Map is not safe for
+	// concurrent use, so each scan has its own.
+	types := pgtype.NewMap()
+	arrays := []any{types.SQLScanner(&k.Scopes), types.SQLScanner(&k.Services), types.SQLScanner(&k.Levels)}
+	dest := append(append([]any{&k.ID, &k.UserID, &active}, arrays...), &expiresAt, &entryRate, &byteRate, &quota)
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, false, err
+	}
+	k.ExpiresAt = expiresAt.Time
+	k.RateLimitEntries, k.RateLimitBytes, k.DailyQuota = entryRate.Float64, byteRate.Float64, quota.Int64
+	return &k, active, nil
+}
+
+func (v *Validator) Ping(ctx context.Context) error {
+	return v.db.PingContext(ctx)
+}
+
+// DB is the key database, which also holds the usage and saved search
+// tables.
+func (v *Validator) DB() *sql.DB {
+	return v.db
+}
+
+func (v *Validator) Close() {
+	close(v.usage.stop)
+	<-v.usage.done
+	v.cancel()
+	v.db.Close()
+}
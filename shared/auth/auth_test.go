@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"reflect"
+	"testing"
+)
+
+// fakeRow scans text values as the pgx driver returns them.
+type fakeRow []any
+
+func (r fakeRow) Scan(dest ...any) error {
+	for i, d := range dest {
+		if s, ok := d.(interface{ Scan(any) error }); ok {
+			if err := s.Scan(r[i]); err != nil {
+				return err
+			}
+			continue
+		}
+		reflect.ValueOf(d).Elem().Set(reflect.ValueOf(r[i]))
+	}
+	return nil
+}
+
+func TestScanApiKey(t *testing.T) {
+	var hash string
+	row := fakeRow{"k1", "u1", true, "{ingest,read}", `{"checkout,eu",api}`, "{}", nil, nil, nil, nil, "h"}
+	k, active, err := scanApiKey(row, &hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !active || k.ID != "k1" || k.UserID != "u1" || hash != "h" {
+		t.Errorf("scanApiKey = %+v, active %v, hash %q", k, active, hash)
+	}
+	if !reflect.DeepEqual(k.Scopes, []string{"ingest", "read"}) || !reflect.DeepEqual(k.Services, []string{"checkout,eu", "api"}) || len(k.Levels) != 0 {
+		t.Errorf("scanApiKey lists = %q %q %q", k.Scopes, k.Services, k.Levels)
+	}
+}
@@ -0,0 +1,35 @@
+// Package auth authenticates LogStream clients: API keys looked up in
+// Postgres and cached, and the web app's session tokens.
+package auth
+
+import (
+	"errors"
+	"time"
+)
+
+type Config struct {
+	CacheTTL         time.Duration `yaml:"cache_ttl" env:"AUTH_CACHE_TTL" flag:"auth-cache-ttl" usage:"How long a valid API key is trusted before rechecking Postgres"`
+	NegativeCacheTTL time.Duration `yaml:"negative_cache_ttl" env:"AUTH_NEGATIVE_CACHE_TTL" flag:"auth-negative-cache-ttl" usage:"How long an unknown API key is remembered as invalid"`
+	StaleTTL         time.Duration `yaml:"stale_ttl" env:"AUTH_STALE_TTL" flag:"auth-stale-ttl" usage:"How long a cached valid key keeps working while Postgres is unreachable"`
+	Listen           bool          `yaml:"listen" env:"AUTH_LISTEN" flag:"auth-listen" usage:"Invalidate cached keys via Postgres LISTEN/NOTIFY"`
+	// AllowPlaintextKeys accepts keys that have not been migrated to hashed
+	// storage yet, hashing each one the first time it is used.
+	AllowPlaintextKeys bool `yaml:"allow_plaintext_keys" env:"AUTH_ALLOW_PLAINTEXT_KEYS" flag:"auth-allow-plaintext-keys" usage:"Accept API keys still stored in plaintext and hash them on first use"`
+	// UsageFlushInterval is how often last-used times, IPs and request
+	// counts are written back to "ApiKey".
+	UsageFlushInterval time.Duration `yaml:"usage_flush_interval" env:"AUTH_USAGE_FLUSH_INTERVAL" flag:"auth-usage-flush-interval" usage:"How often API key last-used details are written to Postgres"`
+}
+
+func (c Config) Validate() []error {
+	var errs []error
+	if c.CacheTTL <= 0 || c.NegativeCacheTTL <= 0 {
+		errs = append(errs, errors.New("auth.cache_ttl and auth.negative_cache_ttl must be positive"))
+	}
+	if c.StaleTTL < c.CacheTTL {
+		errs = append(errs, errors.New("auth.stale_ttl must be at least auth.cache_ttl"))
+	}
+	if c.UsageFlushInterval <= 0 {
+		errs = append(errs, errors.New("auth.usage_flush_interval must be positive"))
+	}
+	return errs
+}
@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/davidojo1144/LogStream/shared/metrics"
+)
+
+// maxNegativeKeys bounds how many unknown keys are remembered, so a client
+// spraying random keys cannot grow the cache without limit.
+const maxNegativeKeys = 10000
+
+type cachedKey struct {
+	key        *ApiKey // nil when the lookup found no active key
+	expires    time.Time
+	staleUntil time.Time
+}
+
+// keyCache keeps recent API key lookups in memory. Hits are served for ttl
+// (negativeTTL for misses). After that the database is asked again, but if
+// it cannot answer, a key that was valid keeps working until staleTTL has
+// passed, so a short Postgres outage does not stop ingestion.
+type keyCache struct {
+	ttl         time.Duration
+	negativeTTL time.Duration
+	staleTTL    time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]cachedKey
+	negatives int
+	// gen counts invalidations. A lookup that ran while one happened may
+	// have read the old row, so its result is not cached.
+	gen uint64
+}
+
+func newKeyCache(ttl, negativeTTL, staleTTL time.Duration) *keyCache {
+	c := &keyCache{
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		staleTTL:    staleTTL,
+		entries:     make(map[string]cachedKey),
+	}
+	go c.sweep()
+	return c
+}
+
+// resolve returns the key for lookup, consulting fetch when the cache has no
+// fresh answer. fetch returns nil, nil when there is no active key.
+func (c *keyCache) resolve(lookup string, fetch func() (*ApiKey, error)) (*ApiKey, error) {
+	now := time.Now()
+
+	c.mu.Lock()
+	entry, ok := c.entries[lookup]
+	gen := c.gen
+	c.mu.Unlock()
+
+	if ok && now.Before(entry.expires) {
+		metrics.AuthCacheLookups.WithLabelValues("hit").Inc()
+		return entry.key, nil
+	}
+
+	key, err := fetch()
+	if err != nil {
+		if ok && entry.key != nil && now.Before(entry.staleUntil) {
+			metrics.AuthCacheLookups.WithLabelValues("stale").Inc()
+			log.Printf("Serving cached API key %s while the database is unavailable: %v", entry.key.ID, err)
+			return entry.key, nil
+		}
+		return nil, err
+	}
+
+	metrics.AuthCacheLookups.WithLabelValues("miss").Inc()
+	c.put(lookup, key, now, gen)
+	return key, nil
+}
+
+// put caches the result of a lookup that started at generation gen, unless
+// the cache has been invalidated since.
+func (c *keyCache) put(lookup string, key *ApiKey, now time.Time, gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.gen != gen {
+		return
+	}
+
+	old, existed := c.entries[lookup]
+	if existed && old.key == nil {
+		c.negatives--
+	}
+
+	if key == nil {
+		if c.negatives >= maxNegativeKeys {
+			delete(c.entries, lookup)
+			return
+		}
+		c.negatives++
+		c.entries[lookup] = cachedKey{expires: now.Add(c.negativeTTL)}
+		return
+	}
+
+	c.entries[lookup] = cachedKey{
+		key:        key,
+		expires:    now.Add(c.ttl),
+		staleUntil: now.Add(c.staleTTL),
+	}
+}
+
+// invalidate drops every entry for the key with the given ID. Misses are
+// dropped too, since the change may have been a new key being created.
+func (c *keyCache) invalidate(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for lookup, entry := range c.entries {
+		if entry.key == nil || entry.key.ID == id {
+			delete(c.entries, lookup)
+		}
+	}
+	c.negatives = 0
+	c.gen++
+}
+
+// flush empties the cache, used when notifications may have been missed.
+func (c *keyCache) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[string]cachedKey)
+	c.negatives = 0
+	c.gen++
+}
+
+// sweep periodically removes entries that can no longer be served.
+func (c *keyCache) sweep() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		c.mu.Lock()
+		for lookup, entry := range c.entries {
+			if entry.key == nil && now.After(entry.expires) {
+				delete(c.entries, lookup)
+				c.negatives--
+			} else if entry.key != nil && now.After(entry.staleUntil) && now.After(entry.expires) {
+				delete(c.entries, lookup)
+			}
+		}
+		c.mu.Unlock()
+	}
+}
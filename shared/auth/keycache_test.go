@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestKeyCache(t *testing.T) {
+	c := newKeyCache(time.Minute, time.Minute, time.Hour)
+	fetches := 0
+	fetch := func(k *ApiKey, err error) func() (*ApiKey, error) {
+		return func() (*ApiKey, error) {
+			fetches++
+			return k, err
+		}
+	}
+	key := &ApiKey{ID: "k1"}
+
+	if got, err := c.resolve("key:a", fetch(key, nil)); got != key || err != nil {
+		t.Fatalf("first resolve = %v, %v", got, err)
+	}
+	if got, _ := c.resolve("key:a", fetch(nil, nil)); got != key || fetches != 1 {
+		t.Fatalf("cached resolve = %v after %d fetches, want the cached key after 1", got, fetches)
+	}
+
+	// A revoked key is fetched again after the notification.
+	c.invalidate("k1")
+	if got, _ := c.resolve("key:a", fetch(nil, nil)); got != nil || fetches != 2 {
+		t.Fatalf("resolve after invalidate = %v after %d fetches, want nil after 2", got, fetches)
+	}
+}
+
+func TestKeyCacheStale(t *testing.T) {
+	c := newKeyCache(time.Minute, time.Minute, time.Hour)
+	key := &ApiKey{ID: "k1"}
+	c.resolve("key:a", func() (*ApiKey, error) { return key, nil })
+
+	// Expire the entry but keep it within the stale window.
+	c.mu.Lock()
+	e := c.entries["key:a"]
+	e.expires = time.Now().Add(-time.Second)
+	c.entries["key:a"] = e
+	c.mu.Unlock()
+
+	got, err := c.resolve("key:a", func() (*ApiKey, error) { return nil, errors.New("connection refused") })
+	if got != key || err != nil {
+		t.Fatalf("resolve during an outage = %v, %v, want the stale key", got, err)
+	}
+}
+
+func TestKeyCacheInvalidateDuringFetch(t *testing.T) {
+	c := newKeyCache(time.Minute, time.Minute, time.Hour)
+	old := &ApiKey{ID: "k1"}
+
+	// The key is revoked while its row is being read: the lookup answers
+	// with what it read, but must not cache it past the notification.
+	got, _ := c.resolve("key:a", func() (*ApiKey, error) {
+		c.invalidate("k1")
+		return old, nil
+	})
+	if got != old {
+		t.Fatalf("resolve = %v, want the fetched key", got)
+	}
+	got, _ = c.resolve("key:a", func() (*ApiKey, error) { return nil, nil })
+	if got != nil {
+		t.Errorf("resolve after the racing invalidation = %v, want the key refetched", got)
+	}
+
+	// The same holds when notifications may have been missed.
+	c.resolve("key:b", func() (*ApiKey, error) {
+		c.flush()
+		return old, nil
+	})
+	if got, _ := c.resolve("key:b", func() (*ApiKey, error) { return nil, nil }); got != nil {
+		t.Errorf("resolve after the racing flush = %v, want the key refetched", got)
+	}
+}
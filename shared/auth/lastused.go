@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// keyUsage accumulates the requests made with one key since the last flush.
+type keyUsage struct {
+	at    time.Time
+	ip    string
+	count int64
+}
+
+// usageTracker batches last-used updates in memory so authenticating a
+// request never waits on a database write.
+type usageTracker struct {
+	mu      sync.Mutex
+	pending map[string]*keyUsage
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func newUsageTracker() *usageTracker {
+	return &usageTracker{
+		pending: make(map[string]*keyUsage),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+func (t *usageTracker) record(id, ip string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	u, ok := t.pending[id]
+	if !ok {
+		u = &keyUsage{}
+		t.pending[id] = u
+	}
+	u.at = time.Now().UTC()
+	u.ip = ip
+	u.count++
+}
+
+func (t *usageTracker) drain() map[string]*keyUsage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	pending := t.pending
+	t.pending = make(map[string]*keyUsage)
+	return pending
+}
+
+// Used records that key authenticated r. Keys without an ID, such as web app
+// sessions, are not tracked.
+func (v *Validator) Used(key *ApiKey, r *http.Request) {
+	if key == nil || key.ID == "" {
+		return
+	}
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	v.usage.record(key.ID, ip)
+}
+
+// flushUsage writes batched usage every interval until the tracker is stopped,
+// then writes whatever is left.
+func (v *Validator) flushUsage(interval time.Duration) {
+	defer close(v.usage.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			v.writeUsage()
+		case <-v.usage.stop:
+			v.writeUsage()
+			return
+		}
+	}
+}
+
+// writeUsage updates each key's usage columns. The "ApiKey" change trigger
+// ignores these columns, so this does not invalidate cached keys.
+func (v *Validator) writeUsage() {
+	for id, u := range v.usage.drain() {
+		_, err := v.db.Exec(`UPDATE "ApiKey" SET "lastUsedAt" = GREATEST(COALESCE("lastUsedAt", $2), $2), "lastUsedIp" = $3, "requestCount" = "requestCount" + $4 WHERE id = $1`,
+			id, u.at, u.ip, u.count)
+		if err != nil {
+			log.Printf("Failed to record usage of API key %s: %v", id, err)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/davidojo1144/LogStream/shared/metrics"
+	"github.com/gorilla/websocket"
+)
+
+// ReadAuth authenticates requests to the read endpoints. A caller presents
+// either a read-scoped API key or a session token the web app got from
+// /api/token. Both go in the Authorization header. Browsers cannot set
+// headers on WebSocket upgrades, so /ws also accepts ?access_token=.
+type ReadAuth struct {
+	keys     *Validator       // nil when no key database is configured
+	sessions *SessionVerifier // nil when no session secret is configured
+}
+
+func NewReadAuth(keys *Validator, sessions *SessionVerifier) *ReadAuth {
+	return &ReadAuth{keys: keys, sessions: sessions}
+}
+
+// Authorize returns the key the request acts as, writing an error response
+// and returning false when it is not allowed to read. Web app sessions see
+// every log, so they act as an unrestricted read key.
+func (a *ReadAuth) Authorize(w http.ResponseWriter, r *http.Request) (*ApiKey, bool) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" && websocket.IsWebSocketUpgrade(r) {
+		token = r.URL.Query().Get("access_token")
+	}
+	if token == "" {
+		metrics.AuthFailures.WithLabelValues("missing_key").Inc()
+		http.Error(w, "Missing Authorization header", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	if strings.HasPrefix(token, apiKeyScheme) {
+		var key *ApiKey
+		if a.keys != nil {
+			key = a.keys.Validate(token)
+		}
+		if key == nil {
+			metrics.AuthFailures.WithLabelValues("invalid_key").Inc()
+			http.Error(w, "Invalid API Key", http.StatusUnauthorized)
+			return nil, false
+		}
+		if !key.HasScope(ScopeRead) {
+			metrics.AuthFailures.WithLabelValues("missing_scope").Inc()
+			http.Error(w, "API Key does not have the read scope", http.StatusForbidden)
+			return nil, false
+		}
+		a.keys.Used(key, r)
+		return key, true
+	}
+
+	var session *Session
+	if a.sessions != nil {
+		session, _ = a.sessions.Verify(token)
+	}
+	if session == nil {
+		metrics.AuthFailures.WithLabelValues("invalid_session").Inc()
+		http.Error(w, "Invalid or expired session", http.StatusUnauthorized)
+		return nil, false
+	}
+	return &ApiKey{UserID: session.UserID, Scopes: []string{ScopeRead}}, true
+}
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNextAuthKey(t *testing.T) {
+	// HKDF-SHA256 with an empty salt, computed independently.
+	want := "c459c0999a9034004d4f23dbdec30431d214142ac12fdae6c004ea596c15f4dd"
+	if got := hex.EncodeToString(nextAuthKey("topsecret")); got != want {
+		t.Errorf("nextAuthKey = %s, want %s", got, want)
+	}
+}
+
+// sessionToken encrypts claims as NextAuth does.
+func sessionToken(t *testing.T, secret, header, claims string) string {
+	block, err := aes.NewCipher(nextAuthKey(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := base64.RawURLEncoding
+	protected := enc.EncodeToString([]byte(header))
+	iv := make([]byte, gcm.NonceSize())
+	sealed := gcm.Seal(nil, iv, []byte(claims), []byte(protected))
+	ciphertext, tag := sealed[:len(sealed)-gcm.Overhead()], sealed[len(sealed)-gcm.Overhead():]
+	return strings.Join([]string{protected, "", enc.EncodeToString(iv), enc.EncodeToString(ciphertext), enc.EncodeToString(tag)}, ".")
+}
+
+func TestSessionVerifier(t *testing.T) {
+	v := NewSessionVerifier("topsecret")
+	header := `{"alg":"dir","enc":"A256GCM"}`
+	exp := time.Now().Add(time.Hour).Unix()
+	claims := `{"sub":"u1","email":"a@example.com","exp":` + strconv.FormatInt(exp, 10) + `}`
+
+	s, err := v.Verify(sessionToken(t, "topsecret", header, claims))
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if s.UserID != "u1" || s.Email != "a@example.com" || s.Expires.Unix() != exp {
+		t.Errorf("Verify = %+v", s)
+	}
+
+	valid := sessionToken(t, "topsecret", header, claims)
+	parts := strings.Split(valid, ".")
+	parts[3] = base64.RawURLEncoding.EncodeToString([]byte("tampered"))
+	for name, token := range map[string]string{
+		"other secret": sessionToken(t, "othersecret", header, claims),
+		"other alg":    sessionToken(t, "topsecret", `{"alg":"A256KW","enc":"A256GCM"}`, claims),
+		"expired":      sessionToken(t, "topsecret", header, `{"sub":"u1","exp":1}`),
+		"no subject":   sessionToken(t, "topsecret", header, `{"exp":`+strconv.FormatInt(exp, 10)+`}`),
+		"tampered":     strings.Join(parts, "."),
+		"signed JWT":   "eyJhbGciOiJIUzI1NiJ9.eyJzdWIiOiJ1MSJ9.sig",
+		"empty":        "",
+	} {
+		if s, err := v.Verify(token); err == nil {
+			t.Errorf("%s: Verify = %+v, want an error", name, s)
+		}
+	}
+}
+
+func TestSessionVerifierExpiry(t *testing.T) {
+	v := NewSessionVerifier("topsecret")
+	token := func(claims string) string {
+		return sessionToken(t, "topsecret", `{"alg":"dir","enc":"A256GCM"}`, claims)
+	}
+	now := time.Now().Unix()
+
+	if _, err := v.Verify(token(`{"sub":"u1","exp":` + strconv.FormatInt(now+60, 10) + `}`)); err != nil {
+		t.Errorf("a session expiring in a minute was refused: %v", err)
+	}
+	if _, err := v.Verify(token(`{"sub":"u1","exp":` + strconv.FormatInt(now-1, 10) + `}`)); err == nil {
+		t.Error("a session that expired a second ago was accepted")
+	}
+	// NextAuth always sets exp, so a token without one is not trusted.
+	if _, err := v.Verify(token(`{"sub":"u1"}`)); err == nil {
+		t.Error("a session without exp was accepted")
+	}
+}
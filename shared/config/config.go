@@ -0,0 +1,296 @@
+// Package config loads the configuration of every LogStream binary. Each
+// binary declares a Config struct whose fields carry the settings' sources:
+//
+//	Addr string `yaml:"addr" env:"CLICKHOUSE_ADDR" flag:"clickhouse-addr" usage:"..."`
+//
+// Values are applied in order of increasing precedence: the defaults already
+// in the struct, the YAML file given by -config or LOGSTREAM_CONFIG,
+// environment variables, then command-line flags. Fields tagged
+// `secret:"true"` can also be read from a file named by the YAML key
+// "<key>_file" or the environment variable "<ENV>_FILE", which suits Docker
+// and Kubernetes secrets.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.yaml.in/yaml/v3"
+)
+
+const configEnv = "LOGSTREAM_CONFIG"
+
+type configField struct {
+	value  reflect.Value
+	path   []string // YAML keys from the document root
+	env    string
+	flag   string
+	usage  string
+	secret bool
+}
+
+// Load fills cfg, a pointer to a Config struct, from every source.
+func Load(cfg any, name string, args []string) error {
+	fields := collectConfigFields(reflect.ValueOf(cfg).Elem(), nil)
+
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	configPath := fs.String("config", os.Getenv(configEnv), "Path to a YAML config file (env "+configEnv+")")
+	flags := make(map[string]*rawFlag)
+	for _, f := range fields {
+		if f.flag == "" {
+			continue
+		}
+		rf := &rawFlag{isBool: f.value.Kind() == reflect.Bool}
+		if !f.secret {
+			rf.def = formatConfigValue(f.value)
+		}
+		usage := f.usage
+		if f.env != "" {
+			usage += " (env " + f.env + ")"
+		}
+		fs.Var(rf, f.flag, usage)
+		flags[f.flag] = rf
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *configPath != "" {
+		doc, err := readConfigFile(*configPath)
+		if err != nil {
+			return err
+		}
+		for _, f := range fields {
+			if err := f.applyYAML(doc); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, f := range fields {
+		if err := f.applyEnv(); err != nil {
+			return err
+		}
+	}
+
+	var flagErr error
+	fs.Visit(func(fl *flag.Flag) {
+		rf, ok := flags[fl.Name]
+		if !ok || flagErr != nil {
+			return
+		}
+		for _, f := range fields {
+			if f.flag == fl.Name {
+				if err := setConfigValue(f.value, rf.val); err != nil {
+					flagErr = fmt.Errorf("flag -%s: %w", fl.Name, err)
+				}
+			}
+		}
+	})
+	return flagErr
+}
+
+func collectConfigFields(v reflect.Value, path []string) []configField {
+	var fields []configField
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		key := sf.Tag.Get("yaml")
+		if key == "" {
+			key = strings.ToLower(sf.Name)
+		}
+		fieldPath := append(append([]string{}, path...), key)
+
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Struct {
+			fields = append(fields, collectConfigFields(fv, fieldPath)...)
+			continue
+		}
+		fields = append(fields, configField{
+			value:  fv,
+			path:   fieldPath,
+			env:    sf.Tag.Get("env"),
+			flag:   sf.Tag.Get("flag"),
+			usage:  sf.Tag.Get("usage"),
+			secret: sf.Tag.Get("secret") == "true",
+		})
+	}
+	return fields
+}
+
+func readConfigFile(path string) (map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	doc := make(map[string]any)
+	if err := yaml.Unmarshal(data, &doc); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return doc, nil
+}
+
+func (f configField) applyYAML(doc map[string]any) error {
+	name := strings.Join(f.path, ".")
+	if raw, ok := lookupYAML(doc, f.path); ok {
+		if err := setConfigValue(f.value, yamlString(raw)); err != nil {
+			return fmt.Errorf("config key %s: %w", name, err)
+		}
+	}
+	if !f.secret {
+		return nil
+	}
+
+	filePath := append(append([]string{}, f.path[:len(f.path)-1]...), f.path[len(f.path)-1]+"_file")
+	if raw, ok := lookupYAML(doc, filePath); ok {
+		secret, err := readSecretFile(yamlString(raw))
+		if err != nil {
+			return fmt.Errorf("config key %s_file: %w", name, err)
+		}
+		f.value.SetString(secret)
+	}
+	return nil
+}
+
+func (f configField) applyEnv() error {
+	if f.env == "" {
+		return nil
+	}
+	if s, ok := os.LookupEnv(f.env); ok {
+		if err := setConfigValue(f.value, s); err != nil {
+			return fmt.Errorf("env %s: %w", f.env, err)
+		}
+	}
+	if !f.secret {
+		return nil
+	}
+	if path, ok := os.LookupEnv(f.env + "_FILE"); ok {
+		secret, err := readSecretFile(path)
+		if err != nil {
+			return fmt.Errorf("env %s_FILE: %w", f.env, err)
+		}
+		f.value.SetString(secret)
+	}
+	return nil
+}
+
+func lookupYAML(doc map[string]any, path []string) (any, bool) {
+	var cur any = doc
+	for _, key := range path {
+		m, ok := cur.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// yamlString flattens a scalar or list from the YAML document into the same
+// string form used by environment variables and flags.
+func yamlString(v any) string {
+	if list, ok := v.([]any); ok {
+		parts := make([]string, len(list))
+		for i, item := range list {
+			parts[i] = fmt.Sprint(item)
+		}
+		return strings.Join(parts, ",")
+	}
+	return fmt.Sprint(v)
+}
+
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret: %w", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+func setConfigValue(v reflect.Value, s string) error {
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported config type %s", v.Type())
+	}
+	return nil
+}
+
+func formatConfigValue(v reflect.Value) string {
+	if v.Kind() == reflect.Slice {
+		return strings.Join(v.Interface().([]string), ",")
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// rawFlag records a flag's text so it can be applied after the config file
+// and environment, whatever order the sources are parsed in.
+type rawFlag struct {
+	def    string
+	val    string
+	isBool bool
+}
+
+func (f *rawFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.def
+}
+
+func (f *rawFlag) Set(s string) error {
+	f.val = s
+	return nil
+}
+
+func (f *rawFlag) IsBoolFlag() bool {
+	return f.isBool
+}
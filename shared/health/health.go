@@ -0,0 +1,96 @@
+// Package health serves the liveness and readiness endpoints.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Check probes one dependency. A failing critical check makes the
+// service unready; a failing non-critical one only marks it degraded, for
+// dependencies the service can ride out for a while.
+type Check struct {
+	Name     string
+	Critical bool
+	Check    func(ctx context.Context) error
+}
+
+type checkResult struct {
+	Status    string `json:"status"`
+	Critical  bool   `json:"critical"`
+	LatencyMs int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+type healthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks"`
+}
+
+// Health serves /livez and /readyz.
+type Health struct {
+	checks  []Check
+	timeout time.Duration
+}
+
+func New(timeout time.Duration, checks ...Check) *Health {
+	return &Health{checks: checks, timeout: timeout}
+}
+
+// Livez reports that the process is up and serving HTTP. It deliberately
+// ignores dependencies so an outage elsewhere never gets the process killed.
+func (h *Health) Livez(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status":"ok"}`))
+}
+
+// Readyz runs every check concurrently, each bounded by the health timeout,
+// and answers 503 only when a critical dependency is down.
+func (h *Health) Readyz(w http.ResponseWriter, r *http.Request) {
+	report := healthReport{Status: "ok", Checks: make(map[string]checkResult, len(h.checks))}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, c := range h.checks {
+		wg.Add(1)
+		go func(c Check) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+			defer cancel()
+
+			start := time.Now()
+			err := c.Check(ctx)
+			res := checkResult{Status: "ok", Critical: c.Critical, LatencyMs: time.Since(start).Milliseconds()}
+			if err != nil {
+				res.Status = "failed"
+				res.Error = err.Error()
+			}
+
+			mu.Lock()
+			report.Checks[c.Name] = res
+			mu.Unlock()
+		}(c)
+	}
+	wg.Wait()
+
+	status := http.StatusOK
+	for _, res := range report.Checks {
+		if res.Status == "ok" {
+			continue
+		}
+		if res.Critical {
+			report.Status = "unavailable"
+			status = http.StatusServiceUnavailable
+		} else if report.Status == "ok" {
+			report.Status = "degraded"
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
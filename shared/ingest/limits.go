@@ -0,0 +1,318 @@
+package ingest
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/davidojo1144/LogStream/shared/auth"
+)
+
+// idleBucketTTL is how long a key's rate limit state is kept after its last
+// request. A bucket idle that long has refilled anyway.
+const idleBucketTTL = 10 * time.Minute
+
+// tokenBucket allows rate units per second with bursts of up to burst.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) refill(rate, burst float64, now time.Time) {
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	}
+	b.rate, b.burst, b.last = rate, burst, now
+}
+
+// wait is how long until n tokens are available.
+func (b *tokenBucket) wait(n float64) time.Duration {
+	if b.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+}
+
+// dailyCount tracks one key's entries for the current UTC day: the total
+// across all servers as of the last flush, plus what this server has
+// accepted since.
+type dailyCount struct {
+	day     string
+	flushed int64
+	pending int64
+}
+
+// dayUsage is what a server accepted for a key on a day and has not yet
+// added to the shared count.
+type dayUsage struct {
+	id, day string
+	entries int64
+}
+
+type keyLimits struct {
+	entries tokenBucket
+	bytes   tokenBucket
+	daily   *dailyCount
+}
+
+// Decision is the outcome of a rate limit check.
+type Decision struct {
+	Allowed    bool
+	Limit      string // "quota", "rate" or "size" when not allowed
+	Status     int    // response status when not allowed
+	Reason     string // why the request was refused
+	RetryAfter time.Duration
+
+	// Quota is zero when the key has no daily quota.
+	Quota          int64
+	QuotaRemaining int64
+	QuotaReset     time.Time
+}
+
+// SetHeaders reports the remaining quota and, for refused requests, when to
+// retry.
+func (d Decision) SetHeaders(w http.ResponseWriter) {
+	if d.Quota > 0 {
+		w.Header().Set("X-Quota-Limit", strconv.FormatInt(d.Quota, 10))
+		w.Header().Set("X-Quota-Remaining", strconv.FormatInt(d.QuotaRemaining, 10))
+		w.Header().Set("X-Quota-Reset", strconv.FormatInt(d.QuotaReset.Unix(), 10))
+	}
+	if !d.Allowed && d.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(d.RetryAfter.Seconds()))))
+	}
+}
+
+type LimitsConfig struct {
+	EntriesPerSecond float64       `yaml:"entries_per_second" env:"LIMIT_ENTRIES_PER_SECOND" flag:"limit-entries-per-second" usage:"Default per-key ingest rate in log entries per second (0 for unlimited)"`
+	BytesPerSecond   float64       `yaml:"bytes_per_second" env:"LIMIT_BYTES_PER_SECOND" flag:"limit-bytes-per-second" usage:"Default per-key ingest rate in request bytes per second (0 for unlimited)"`
+	Burst            time.Duration `yaml:"burst" env:"LIMIT_BURST" flag:"limit-burst" usage:"How much traffic, in time at the full rate, a key may send at once"`
+	FlushInterval    time.Duration `yaml:"flush_interval" env:"LIMIT_FLUSH_INTERVAL" flag:"limit-flush-interval" usage:"How often daily quota usage is shared through Postgres"`
+}
+
+func (c LimitsConfig) Validate() []error {
+	var errs []error
+	if c.EntriesPerSecond < 0 || c.BytesPerSecond < 0 {
+		errs = append(errs, errors.New("limits.entries_per_second and limits.bytes_per_second must not be negative"))
+	}
+	if c.Burst < time.Second {
+		errs = append(errs, errors.New("limits.burst must be at least 1s"))
+	}
+	if c.FlushInterval <= 0 {
+		errs = append(errs, errors.New("limits.flush_interval must be positive"))
+	}
+	return errs
+}
+
+// Limiter enforces per-key ingest rates and daily entry quotas. Rates are
+// per server; daily counts are shared through "ApiKeyDailyUsage" and so can
+// overshoot by what other servers accepted within one flush interval.
+type Limiter struct {
+	cfg LimitsConfig
+	db  *sql.DB
+
+	mu   sync.Mutex
+	keys map[string]*keyLimits
+	// ended holds the unflushed counts of days that are over, so a
+	// rollover between flushes does not lose them.
+	ended []dayUsage
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewLimiter(db *sql.DB, cfg LimitsConfig) *Limiter {
+	l := &Limiter{
+		cfg:  cfg,
+		db:   db,
+		keys: make(map[string]*keyLimits),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+// Allow checks and, if allowed, charges a request of entries log entries and
+// size bytes against key.
+func (l *Limiter) Allow(key *auth.ApiKey, entries int, size int64) Decision {
+	now := time.Now().UTC()
+	day := now.Format("2006-01-02")
+
+	entryRate, byteRate := l.cfg.EntriesPerSecond, l.cfg.BytesPerSecond
+	if key.RateLimitEntries > 0 {
+		entryRate = key.RateLimitEntries
+	}
+	if key.RateLimitBytes > 0 {
+		byteRate = key.RateLimitBytes
+	}
+
+	// Fetch today's shared count outside the lock the first time it is
+	// needed.
+	var loaded *dailyCount
+	if key.DailyQuota > 0 && l.needsDaily(key.ID, day) {
+		loaded = l.loadDaily(key.ID, day)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	k, ok := l.keys[key.ID]
+	if !ok {
+		k = &keyLimits{}
+		l.keys[key.ID] = k
+	}
+	if k.daily == nil || k.daily.day != day {
+		if k.daily != nil && k.daily.pending > 0 {
+			l.ended = append(l.ended, dayUsage{key.ID, k.daily.day, k.daily.pending})
+		}
+		k.daily = &dailyCount{day: day}
+	}
+	if loaded != nil && k.daily.flushed == 0 {
+		k.daily.flushed = loaded.flushed
+	}
+
+	d := Decision{Allowed: true}
+	if key.DailyQuota > 0 {
+		used := k.daily.flushed + k.daily.pending
+		d.Quota = key.DailyQuota
+		d.QuotaRemaining = max(key.DailyQuota-used, 0)
+		d.QuotaReset = now.Truncate(24 * time.Hour).Add(24 * time.Hour)
+		if used+int64(entries) > key.DailyQuota {
+			d.Allowed, d.Limit, d.Status, d.Reason = false, "quota", http.StatusTooManyRequests, "daily quota exceeded"
+			d.RetryAfter = d.QuotaReset.Sub(now)
+			return d
+		}
+	}
+
+	burst := l.cfg.Burst.Seconds()
+	if entryRate > 0 {
+		k.entries.refill(entryRate, entryRate*burst, now)
+	}
+	if byteRate > 0 {
+		k.bytes.refill(byteRate, byteRate*burst, now)
+		if float64(size) > k.bytes.burst {
+			d.Allowed, d.Limit, d.Status = false, "size", http.StatusRequestEntityTooLarge
+			d.Reason = fmt.Sprintf("request of %d bytes exceeds the key's burst of %.0f bytes", size, k.bytes.burst)
+			return d
+		}
+	}
+
+	var wait time.Duration
+	if entryRate > 0 {
+		wait = k.entries.wait(float64(entries))
+	}
+	if byteRate > 0 {
+		wait = max(wait, k.bytes.wait(float64(size)))
+	}
+	if wait > 0 {
+		d.Allowed, d.Limit, d.Status, d.Reason = false, "rate", http.StatusTooManyRequests, "rate limit exceeded"
+		d.RetryAfter = wait
+		return d
+	}
+
+	if entryRate > 0 {
+		k.entries.tokens -= float64(entries)
+	}
+	if byteRate > 0 {
+		k.bytes.tokens -= float64(size)
+	}
+	k.daily.pending += int64(entries)
+	if d.Quota > 0 {
+		d.QuotaRemaining = max(d.QuotaRemaining-int64(entries), 0)
+	}
+	return d
+}
+
+func (l *Limiter) needsDaily(id, day string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	k, ok := l.keys[id]
+	return !ok || k.daily == nil || k.daily.day != day
+}
+
+// loadDaily reads the shared count for a key and day. On error the key
+// starts from this server's own count rather than being refused.
+func (l *Limiter) loadDaily(id, day string) *dailyCount {
+	var entries int64
+	err := l.db.QueryRow(`SELECT entries FROM "ApiKeyDailyUsage" WHERE "apiKeyId" = $1 AND day = $2`, id, day).Scan(&entries)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Failed to load daily usage of API key %s: %v", id, err)
+	}
+	return &dailyCount{day: day, flushed: entries}
+}
+
+func (l *Limiter) run() {
+	defer close(l.done)
+
+	ticker := time.NewTicker(l.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			l.flush()
+		case <-l.stop:
+			l.flush()
+			return
+		}
+	}
+}
+
+// flush adds pending entries to the shared daily counts, picks up what other
+// servers added, and drops idle keys.
+func (l *Limiter) flush() {
+	l.mu.Lock()
+	batch := l.ended
+	l.ended = nil
+	now := time.Now()
+	for id, k := range l.keys {
+		if k.daily != nil && k.daily.pending > 0 {
+			batch = append(batch, dayUsage{id, k.daily.day, k.daily.pending})
+			k.daily.pending = 0
+		} else if now.Sub(k.entries.last) > idleBucketTTL && now.Sub(k.bytes.last) > idleBucketTTL {
+			delete(l.keys, id)
+		}
+	}
+	l.mu.Unlock()
+
+	for _, p := range batch {
+		var total int64
+		err := l.db.QueryRow(`INSERT INTO "ApiKeyDailyUsage" ("apiKeyId", day, entries) VALUES ($1, $2, $3)
+			ON CONFLICT ("apiKeyId", day) DO UPDATE SET entries = "ApiKeyDailyUsage".entries + EXCLUDED.entries
+			RETURNING entries`, p.id, p.day, p.entries).Scan(&total)
+
+		l.mu.Lock()
+		k, ok := l.keys[p.id]
+		switch {
+		case ok && k.daily != nil && k.daily.day == p.day:
+			if err != nil {
+				k.daily.pending += p.entries // retry on the next flush
+			} else {
+				k.daily.flushed = total
+			}
+		case err != nil:
+			l.ended = append(l.ended, p)
+		}
+		l.mu.Unlock()
+
+		if err != nil {
+			log.Printf("Failed to record daily usage of API key %s: %v", p.id, err)
+		}
+	}
+}
+
+func (l *Limiter) Close() {
+	close(l.stop)
+	<-l.done
+}
@@ -0,0 +1,123 @@
+package ingest
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/davidojo1144/LogStream/shared/auth"
+)
+
+func TestTokenBucket(t *testing.T) {
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	var b tokenBucket
+
+	b.refill(10, 20, start)
+	if b.tokens != 20 {
+		t.Fatalf("a new bucket holds %v tokens, want the burst of 20", b.tokens)
+	}
+	b.tokens -= 20
+	if w := b.wait(5); w != 500*time.Millisecond {
+		t.Errorf("wait(5) on an empty bucket = %v, want 500ms", w)
+	}
+
+	b.refill(10, 20, start.Add(time.Second))
+	if b.tokens != 10 || b.wait(10) != 0 {
+		t.Errorf("after 1s the bucket holds %v tokens, want 10", b.tokens)
+	}
+	b.refill(10, 20, start.Add(time.Minute))
+	if b.tokens != 20 {
+		t.Errorf("after a minute the bucket holds %v tokens, want at most the burst of 20", b.tokens)
+	}
+}
+
+// newTestLimiter returns a limiter without a database or flushes.
+func newTestLimiter(cfg LimitsConfig) *Limiter {
+	return &Limiter{cfg: cfg, keys: make(map[string]*keyLimits)}
+}
+
+func TestLimiterRate(t *testing.T) {
+	l := newTestLimiter(LimitsConfig{EntriesPerSecond: 5, Burst: 2 * time.Second})
+	key := &auth.ApiKey{ID: "k1"}
+
+	if d := l.Allow(key, 10, 100); !d.Allowed {
+		t.Fatalf("a burst of 10 entries was refused: %+v", d)
+	}
+	d := l.Allow(key, 1, 10)
+	if d.Allowed || d.Limit != "rate" || d.Status != http.StatusTooManyRequests || d.RetryAfter <= 0 || d.RetryAfter > 200*time.Millisecond {
+		t.Errorf("Allow past the burst = %+v, want refused for up to 200ms", d)
+	}
+
+	// Each key has its own bucket, and a key's own rate overrides the default.
+	if d := l.Allow(&auth.ApiKey{ID: "k2", RateLimitEntries: 100}, 150, 10); !d.Allowed {
+		t.Errorf("another key with a burst of 200 was refused: %+v", d)
+	}
+}
+
+func TestLimiterSize(t *testing.T) {
+	l := newTestLimiter(LimitsConfig{BytesPerSecond: 1000, Burst: time.Second})
+	key := &auth.ApiKey{ID: "k1"}
+
+	d := l.Allow(key, 1, 1001)
+	if d.Allowed || d.Limit != "size" || d.Status != http.StatusRequestEntityTooLarge {
+		t.Errorf("Allow of a request larger than the burst = %+v, want refused as too large", d)
+	}
+	if d := l.Allow(key, 1, 1000); !d.Allowed {
+		t.Errorf("Allow of a request the size of the burst = %+v", d)
+	}
+}
+
+func TestLimiterQuota(t *testing.T) {
+	l := newTestLimiter(LimitsConfig{Burst: time.Second})
+	key := &auth.ApiKey{ID: "k1", DailyQuota: 100}
+	day := time.Now().UTC().Format("2006-01-02")
+	// As if today's shared count had been loaded: 90 entries elsewhere.
+	l.keys[key.ID] = &keyLimits{daily: &dailyCount{day: day, flushed: 90}}
+
+	d := l.Allow(key, 10, 100)
+	if !d.Allowed || d.Quota != 100 || d.QuotaRemaining != 0 {
+		t.Fatalf("Allow up to the quota = %+v", d)
+	}
+	d = l.Allow(key, 1, 10)
+	if d.Allowed || d.Limit != "quota" || d.RetryAfter <= 0 || d.RetryAfter > 24*time.Hour {
+		t.Errorf("Allow past the quota = %+v, want refused until the next UTC day", d)
+	}
+	if got := l.keys[key.ID].daily.pending; got != 10 {
+		t.Errorf("pending = %d, want only the accepted 10 entries", got)
+	}
+}
+
+func TestLimiterKeepsYesterdaysPendingAtRollover(t *testing.T) {
+	l := newTestLimiter(LimitsConfig{Burst: time.Second})
+	key := &auth.ApiKey{ID: "k1"}
+	yesterday := time.Now().UTC().AddDate(0, 0, -1).Format("2006-01-02")
+	// Accepted before midnight, but not flushed yet.
+	l.keys[key.ID] = &keyLimits{daily: &dailyCount{day: yesterday, flushed: 40, pending: 7}}
+
+	if d := l.Allow(key, 3, 30); !d.Allowed {
+		t.Fatalf("Allow on the new day = %+v", d)
+	}
+	daily := l.keys[key.ID].daily
+	if daily.day == yesterday || daily.flushed != 0 || daily.pending != 3 {
+		t.Errorf("the new day starts as %+v, want only the 3 entries just accepted", *daily)
+	}
+	if len(l.ended) != 1 || l.ended[0] != (dayUsage{"k1", yesterday, 7}) {
+		t.Errorf("ended = %v, want yesterday's 7 pending entries kept for the next flush", l.ended)
+	}
+
+	// Nothing is carried over for a day that was fully flushed.
+	l.keys["k2"] = &keyLimits{daily: &dailyCount{day: yesterday, flushed: 12}}
+	l.Allow(&auth.ApiKey{ID: "k2"}, 1, 10)
+	if len(l.ended) != 1 {
+		t.Errorf("ended = %v after a flushed day rolled over", l.ended)
+	}
+}
+
+func TestLimitsConfigValidate(t *testing.T) {
+	if errs := (LimitsConfig{Burst: time.Second, FlushInterval: time.Second}).Validate(); len(errs) != 0 {
+		t.Errorf("Validate of a valid config = %v", errs)
+	}
+	if errs := (LimitsConfig{EntriesPerSecond: -1}).Validate(); len(errs) != 3 {
+		t.Errorf("Validate = %v, want 3 errors", errs)
+	}
+}
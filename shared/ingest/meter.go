@@ -0,0 +1,112 @@
+package ingest
+
+import (
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/davidojo1144/LogStream/shared/auth"
+)
+
+type meterKey struct {
+	apiKeyID string
+	service  string
+	day      string
+}
+
+type meterCount struct {
+	entries int64
+	bytes   int64
+}
+
+// Meter records ingested entries and bytes per API key, service and UTC day
+// in "IngestUsage", for billing teams by log volume. Counts are batched in
+// memory and added to the table every flush interval.
+type Meter struct {
+	db *sql.DB
+
+	mu      sync.Mutex
+	pending map[meterKey]*meterCount
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewMeter(db *sql.DB, flushInterval time.Duration) *Meter {
+	m := &Meter{
+		db:      db,
+		pending: make(map[meterKey]*meterCount),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go m.run(flushInterval)
+	return m
+}
+
+func (m *Meter) Record(key *auth.ApiKey, service string, entries int, bytes int64) {
+	k := meterKey{apiKeyID: key.ID, service: service, day: time.Now().UTC().Format("2006-01-02")}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.pending[k]
+	if !ok {
+		c = &meterCount{}
+		m.pending[k] = c
+	}
+	c.entries += int64(entries)
+	c.bytes += bytes
+}
+
+func (m *Meter) run(interval time.Duration) {
+	defer close(m.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.flush()
+		case <-m.stop:
+			m.flush()
+			return
+		}
+	}
+}
+
+func (m *Meter) flush() {
+	m.mu.Lock()
+	pending := m.pending
+	m.pending = make(map[meterKey]*meterCount)
+	m.mu.Unlock()
+
+	for k, c := range pending {
+		_, err := m.db.Exec(`INSERT INTO "IngestUsage" ("apiKeyId", service, day, entries, bytes) VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT ("apiKeyId", service, day) DO UPDATE SET entries = "IngestUsage".entries + EXCLUDED.entries, bytes = "IngestUsage".bytes + EXCLUDED.bytes`,
+			k.apiKeyID, k.service, k.day, c.entries, c.bytes)
+		if err != nil {
+			log.Printf("Failed to record usage for API key %s, service %q: %v", k.apiKeyID, k.service, err)
+			m.requeue(k, c)
+		}
+	}
+}
+
+// requeue puts counts that could not be written back for the next flush.
+func (m *Meter) requeue(k meterKey, c *meterCount) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if cur, ok := m.pending[k]; ok {
+		cur.entries += c.entries
+		cur.bytes += c.bytes
+		return
+	}
+	m.pending[k] = c
+}
+
+func (m *Meter) Close() {
+	close(m.stop)
+	<-m.done
+}
@@ -0,0 +1,21 @@
+// Package ingest applies the per-key limits and metering of the ingest
+// endpoints.
+package ingest
+
+import "io"
+
+// MaxBodyBytes is the largest ingest request body accepted. A body holds a
+// single log entry.
+const MaxBodyBytes = 1 << 20
+
+// CountingReader tallies the bytes read from a request body in N.
+type CountingReader struct {
+	R io.Reader
+	N int64
+}
+
+func (c *CountingReader) Read(p []byte) (int, error) {
+	n, err := c.R.Read(p)
+	c.N += int64(n)
+	return n, err
+}
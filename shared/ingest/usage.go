@@ -0,0 +1,133 @@
+package ingest
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/davidojo1144/LogStream/shared/auth"
+	"github.com/davidojo1144/LogStream/shared/metrics"
+)
+
+// UsageRow is the volume one API key ingested for one service on one day.
+type UsageRow struct {
+	Day        string `json:"day"`
+	ApiKeyID   string `json:"api_key_id"`
+	ApiKeyName string `json:"api_key_name"`
+	Service    string `json:"service"`
+	Entries    int64  `json:"entries"`
+	Bytes      int64  `json:"bytes"`
+}
+
+type usageReport struct {
+	From    string     `json:"from"`
+	To      string     `json:"to"`
+	Entries int64      `json:"total_entries"`
+	Bytes   int64      `json:"total_bytes"`
+	Rows    []UsageRow `json:"rows"`
+}
+
+// UsageHandler serves /usage: the metered ingest volume of the caller's own
+// API keys, as recorded by the collector in "IngestUsage". Web app sessions
+// see all of their user's keys; API keys need the admin scope.
+type UsageHandler struct {
+	db   *sql.DB
+	auth *auth.ReadAuth
+}
+
+func NewUsageHandler(db *sql.DB, auth *auth.ReadAuth) *UsageHandler {
+	return &UsageHandler{db: db, auth: auth}
+}
+
+// ServeHTTP accepts from and to (inclusive, YYYY-MM-DD, default the last 30
+// days), optional service and api_key_id filters, and format=csv.
+func (h *UsageHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key, ok := h.auth.Authorize(w, r)
+	if !ok {
+		return
+	}
+	if key.ID != "" && !key.HasScope(auth.ScopeAdmin) {
+		metrics.AuthFailures.WithLabelValues("missing_scope").Inc()
+		http.Error(w, "API Key does not have the admin scope", http.StatusForbidden)
+		return
+	}
+
+	query := r.URL.Query()
+	to := time.Now().UTC()
+	from := to.AddDate(0, 0, -29)
+	for name, dst := range map[string]*time.Time{"from": &from, "to": &to} {
+		if v := query.Get(name); v != "" {
+			parsed, err := time.Parse("2006-01-02", v)
+			if err != nil {
+				http.Error(w, name+" must be a date like 2024-05-01", http.StatusBadRequest)
+				return
+			}
+			*dst = parsed
+		}
+	}
+
+	sqlQuery := `SELECT to_char(u.day, 'YYYY-MM-DD'), u."apiKeyId", COALESCE(k.name, ''), u.service, u.entries, u.bytes
+		FROM "IngestUsage" u JOIN "ApiKey" k ON k.id = u."apiKeyId"
+		WHERE k."userId" = $1 AND u.day BETWEEN $2 AND $3`
+	args := []interface{}{key.UserID, from.Format("2006-01-02"), to.Format("2006-01-02")}
+	if service := query.Get("service"); service != "" {
+		args = append(args, service)
+		sqlQuery += " AND u.service = $" + strconv.Itoa(len(args))
+	}
+	if id := query.Get("api_key_id"); id != "" {
+		args = append(args, id)
+		sqlQuery += ` AND u."apiKeyId" = $` + strconv.Itoa(len(args))
+	}
+	sqlQuery += ` ORDER BY u.day, k.name, u.service`
+
+	start := time.Now()
+	rows, err := h.db.QueryContext(r.Context(), sqlQuery, args...)
+	metrics.ObserveQuery("usage", start, &err)
+	if err != nil {
+		log.Printf("Error querying usage: %v", err)
+		http.Error(w, "Failed to fetch usage", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	report := usageReport{From: from.Format("2006-01-02"), To: to.Format("2006-01-02"), Rows: []UsageRow{}}
+	for rows.Next() {
+		var u UsageRow
+		if err := rows.Scan(&u.Day, &u.ApiKeyID, &u.ApiKeyName, &u.Service, &u.Entries, &u.Bytes); err != nil {
+			log.Printf("Error reading usage: %v", err)
+			http.Error(w, "Failed to fetch usage", http.StatusInternalServerError)
+			return
+		}
+		report.Entries += u.Entries
+		report.Bytes += u.Bytes
+		report.Rows = append(report.Rows, u)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error reading usage: %v", err)
+		http.Error(w, "Failed to fetch usage", http.StatusInternalServerError)
+		return
+	}
+
+	if query.Get("format") == "csv" {
+		writeUsageCSV(w, report)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+func writeUsageCSV(w http.ResponseWriter, report usageReport) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="usage-`+report.From+`-to-`+report.To+`.csv"`)
+
+	out := csv.NewWriter(w)
+	out.Write([]string{"day", "api_key_id", "api_key_name", "service", "entries", "bytes"})
+	for _, u := range report.Rows {
+		out.Write([]string{u.Day, u.ApiKeyID, u.ApiKeyName, u.Service, strconv.FormatInt(u.Entries, 10), strconv.FormatInt(u.Bytes, 10)})
+	}
+	out.Flush()
+}
@@ -0,0 +1,271 @@
+package logquery
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxHistogramBins = 100
+
+var defaultQuantiles = []float64{0.5, 0.95, 0.99}
+
+// NumericStats summarizes the values of a numeric metadata field. Quantiles
+// are keyed like p50 and p99.9.
+type NumericStats struct {
+	Count     uint64             `json:"count"`
+	Sum       float64            `json:"sum"`
+	Avg       float64            `json:"avg"`
+	Min       float64            `json:"min"`
+	Max       float64            `json:"max"`
+	Quantiles map[string]float64 `json:"quantiles"`
+}
+
+// AggregateBucket is one time bucket of /aggregate. Without group_by it has
+// the stats of the whole bucket, with group_by only those of each group.
+type AggregateBucket struct {
+	Timestamp time.Time `json:"timestamp"`
+	*NumericStats
+	Groups map[string]NumericStats `json:"groups,omitempty"`
+}
+
+// HistogramBin counts the values from Lower up to Upper; the last bin
+// includes Upper.
+type HistogramBin struct {
+	Lower float64 `json:"lower"`
+	Upper float64 `json:"upper"`
+	Count uint64  `json:"count"`
+}
+
+// AggregateResult is the /aggregate response. Buckets without values are
+// left out, since they have no average or quantiles to chart.
+type AggregateResult struct {
+	Field     string            `json:"field"`
+	Interval  string            `json:"interval"`
+	Timezone  string            `json:"timezone"`
+	GroupBy   string            `json:"group_by,omitempty"`
+	Buckets   []AggregateBucket `json:"buckets"`
+	Histogram []HistogramBin    `json:"histogram,omitempty"`
+}
+
+// AggregateParams are the options of /aggregate: the bucketing of /stats
+// plus the field to aggregate.
+type AggregateParams struct {
+	StatsParams
+	Field     string // metadata.<key>
+	Quantiles []float64
+	Bins      int // histogram bins, 0 for none
+}
+
+// ParseAggregateParams reads field, a numeric metadata.<key>; quantiles, a
+// comma-separated list between 0 and 1 (default 0.5,0.95,0.99); histogram,
+// the number of histogram bins; and the interval, tz and group_by of /stats.
+func ParseAggregateParams(query url.Values, start, end time.Time) (AggregateParams, error) {
+	stats, err := ParseStatsParams(query, start, end)
+	if err != nil {
+		return AggregateParams{}, err
+	}
+	p := AggregateParams{StatsParams: stats, Field: query.Get("field"), Quantiles: defaultQuantiles}
+
+	if !strings.HasPrefix(p.Field, "metadata.") || len(p.Field) == len("metadata.") {
+		return p, fmt.Errorf("field must be metadata.<key>")
+	}
+
+	if v := query.Get("quantiles"); v != "" {
+		p.Quantiles = nil
+		for _, s := range strings.Split(v, ",") {
+			q, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+			if err != nil || q < 0 || q > 1 {
+				return p, fmt.Errorf("quantiles must be numbers between 0 and 1")
+			}
+			p.Quantiles = append(p.Quantiles, q)
+		}
+	}
+
+	if v := query.Get("histogram"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxHistogramBins {
+			return p, fmt.Errorf("histogram must be between 1 and %d bins", maxHistogramBins)
+		}
+		p.Bins = n
+	}
+	return p, nil
+}
+
+func (p AggregateParams) value(c *sqlCompiler) string {
+	return c.metadataNumber(strings.TrimPrefix(p.Field, "metadata."))
+}
+
+// Condition limits the aggregation to logs whose field is a number.
+func (p AggregateParams) Condition(dialect Dialect, firstArg int) (string, []interface{}) {
+	c := &sqlCompiler{dialect: dialect, next: firstArg}
+	return p.value(c) + " IS NOT NULL", c.args
+}
+
+// Columns returns the bucket, group and aggregate columns of the query, to
+// be scanned by AggregateRow.Dest.
+func (p AggregateParams) Columns(dialect Dialect, firstArg int) (string, []interface{}) {
+	c := &sqlCompiler{dialect: dialect, next: firstArg}
+	cols := []string{p.bucketSQL(c) + " AS bucket", p.groupSQL(c) + " AS grp"}
+
+	// The field is compiled for each use so that ClickHouse gets an
+	// argument for every placeholder.
+	x := func() string {
+		if dialect == ClickHouse {
+			// Aggregates over a Nullable column are Nullable; Condition
+			// leaves only numbers.
+			return "assumeNotNull(" + p.value(c) + ")"
+		}
+		return "(" + p.value(c) + ")::float8"
+	}
+	if dialect == ClickHouse {
+		cols = append(cols, "count()")
+	} else {
+		cols = append(cols, "count(*)")
+	}
+	for _, f := range []string{"sum", "avg", "min", "max"} {
+		cols = append(cols, f+"("+x()+")")
+	}
+
+	if dialect == ClickHouse {
+		levels := make([]string, len(p.Quantiles))
+		for i, q := range p.Quantiles {
+			levels[i] = strconv.FormatFloat(q, 'f', -1, 64)
+		}
+		cols = append(cols, "quantiles("+strings.Join(levels, ", ")+")("+x()+")")
+	} else {
+		for _, q := range p.Quantiles {
+			cols = append(cols, "percentile_cont("+strconv.FormatFloat(q, 'f', -1, 64)+") WITHIN GROUP (ORDER BY "+x()+")")
+		}
+	}
+	return strings.Join(cols, ", "), c.args
+}
+
+// AggregateRow is one bucket and group as aggregated by the database.
+type AggregateRow struct {
+	Bucket    time.Time
+	Group     string
+	Stats     NumericStats
+	Quantiles []float64
+}
+
+// Dest is the scan destination for the columns of Columns. ClickHouse
+// returns the quantiles as one array, Postgres as a column each.
+func (r *AggregateRow) Dest(dialect Dialect, quantiles int) []interface{} {
+	dest := []interface{}{&r.Bucket, &r.Group, &r.Stats.Count, &r.Stats.Sum, &r.Stats.Avg, &r.Stats.Min, &r.Stats.Max}
+	if dialect == ClickHouse {
+		return append(dest, &r.Quantiles)
+	}
+	r.Quantiles = make([]float64, quantiles)
+	for i := range r.Quantiles {
+		dest = append(dest, &r.Quantiles[i])
+	}
+	return dest
+}
+
+// BuildAggregate orders the buckets and keeps the groups with the most
+// values.
+func (p AggregateParams) BuildAggregate(rows []AggregateRow) AggregateResult {
+	res := AggregateResult{
+		Field:    p.Field,
+		Interval: formatInterval(p.Interval),
+		Timezone: p.Location.String(),
+		GroupBy:  p.GroupBy,
+		Buckets:  []AggregateBucket{},
+	}
+
+	counts := make([]StatsRow, len(rows))
+	for i, r := range rows {
+		counts[i] = StatsRow{Group: r.Group, Count: r.Stats.Count}
+	}
+	groups := p.topGroups(counts)
+
+	index := make(map[int64]int)
+	for _, r := range rows {
+		stats := r.Stats
+		stats.Quantiles = make(map[string]float64, len(p.Quantiles))
+		for i, q := range p.Quantiles {
+			if i < len(r.Quantiles) {
+				stats.Quantiles[quantileName(q)] = r.Quantiles[i]
+			}
+		}
+
+		i, ok := index[r.Bucket.Unix()]
+		if !ok {
+			i = len(res.Buckets)
+			index[r.Bucket.Unix()] = i
+			res.Buckets = append(res.Buckets, AggregateBucket{Timestamp: r.Bucket.In(p.Location)})
+		}
+		bucket := &res.Buckets[i]
+		if p.GroupBy == "" {
+			bucket.NumericStats = &stats
+			continue
+		}
+		// Quantiles cannot be merged, so the smaller groups are left out
+		// rather than added up.
+		name := p.groupName(r.Group)
+		if !groups[name] || name == statsOtherGroup {
+			continue
+		}
+		if bucket.Groups == nil {
+			bucket.Groups = make(map[string]NumericStats)
+		}
+		bucket.Groups[name] = stats
+	}
+	sort.Slice(res.Buckets, func(i, j int) bool { return res.Buckets[i].Timestamp.Before(res.Buckets[j].Timestamp) })
+	return res
+}
+
+// quantileName names a quantile as a percentile: 0.5 is p50, 0.999 p99.9.
+func quantileName(q float64) string {
+	return "p" + strconv.FormatFloat(math.Round(q*1e6)/1e4, 'f', -1, 64)
+}
+
+// AggregateRange is the smallest and largest value in rows, and whether
+// there are any.
+func AggregateRange(rows []AggregateRow) (lo, hi float64, ok bool) {
+	lo, hi = math.Inf(1), math.Inf(-1)
+	for _, r := range rows {
+		if r.Stats.Count > 0 {
+			lo, hi, ok = math.Min(lo, r.Stats.Min), math.Max(hi, r.Stats.Max), true
+		}
+	}
+	return lo, hi, ok
+}
+
+// HistogramColumn returns the histogram bin of the field for bins of equal
+// width from lo to hi, the bin of hi being the last one.
+func (p AggregateParams) HistogramColumn(dialect Dialect, firstArg int, lo, hi float64) (string, []interface{}) {
+	c := &sqlCompiler{dialect: dialect, next: firstArg}
+	if hi <= lo {
+		// All values are equal and fall in one bin.
+		if dialect == ClickHouse {
+			return "toInt64(0)", nil
+		}
+		return "0", nil
+	}
+	x, width := p.value(c), (hi-lo)/float64(p.Bins)
+	if dialect == ClickHouse {
+		return fmt.Sprintf("least(toInt64(floor((assumeNotNull(%s) - %s) / %s)), %s)", x, c.arg(lo), c.arg(width), c.arg(p.Bins-1)), c.args
+	}
+	return fmt.Sprintf("least(floor(((%s)::float8 - %s::float8) / %s::float8)::int, %s::int)", x, c.arg(lo), c.arg(width), c.arg(p.Bins-1)), c.args
+}
+
+// BuildHistogram sets the bins of the histogram from lo to hi from the
+// counts per bin.
+func (p AggregateParams) BuildHistogram(res *AggregateResult, lo, hi float64, counts map[int64]uint64) {
+	if hi <= lo {
+		res.Histogram = []HistogramBin{{Lower: lo, Upper: hi, Count: counts[0]}}
+		return
+	}
+	width := (hi - lo) / float64(p.Bins)
+	res.Histogram = make([]HistogramBin, p.Bins)
+	for i := range res.Histogram {
+		res.Histogram[i] = HistogramBin{Lower: lo + float64(i)*width, Upper: lo + float64(i+1)*width, Count: counts[int64(i)]}
+	}
+	res.Histogram[p.Bins-1].Upper = hi
+}
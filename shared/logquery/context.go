@@ -0,0 +1,100 @@
+package logquery
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/davidojo1144/LogStream/shared/logs"
+)
+
+const (
+	defaultContextLines = 50
+	maxContextLines     = 500
+)
+
+// contextWindows are how far from the entry each side looks, widest last. A
+// wider window is only searched when the narrower one held too few entries.
+var contextWindows = []time.Duration{time.Hour, 24 * time.Hour, 30 * 24 * time.Hour}
+
+// ContextSide returns up to limit entries from one side of an entry. query
+// fetches them from within window of the entry's timestamp and is called
+// with wider windows until it finds limit entries or the widest is searched.
+func ContextSide(limit int, query func(window time.Duration) ([]logs.Entry, error)) ([]logs.Entry, error) {
+	if limit == 0 {
+		return []logs.Entry{}, nil
+	}
+	var entries []logs.Entry
+	for _, window := range contextWindows {
+		var err error
+		if entries, err = query(window); err != nil || len(entries) >= limit {
+			return entries, err
+		}
+	}
+	return entries, nil
+}
+
+// LogContext is the /logs/{id}/context response: the entry and the entries
+// logged just before and after it, oldest first.
+type LogContext struct {
+	Before []logs.Entry `json:"before"`
+	Log    logs.Entry   `json:"log"`
+	After  []logs.Entry `json:"after"`
+}
+
+// ContextParams are the options of /logs/{id}/context.
+type ContextParams struct {
+	Before, After int
+	Same          []string // service or metadata.<key>
+}
+
+// ParseContextParams reads before and after, the number of entries on each
+// side (default 50), and same, a comma-separated list of service and
+// metadata.<key> fields the entries must share with the one in the middle.
+func ParseContextParams(query url.Values) (ContextParams, error) {
+	p := ContextParams{Before: defaultContextLines, After: defaultContextLines}
+
+	for name, n := range map[string]*int{"before": &p.Before, "after": &p.After} {
+		v := query.Get(name)
+		if v == "" {
+			continue
+		}
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 0 || parsed > maxContextLines {
+			return p, fmt.Errorf("%s must be between 0 and %d", name, maxContextLines)
+		}
+		*n = parsed
+	}
+
+	if v := query.Get("same"); v != "" {
+		for _, field := range strings.Split(v, ",") {
+			field = strings.TrimSpace(field)
+			if field != "service" && (!strings.HasPrefix(field, "metadata.") || len(field) == len("metadata.")) {
+				return p, fmt.Errorf("same must list service or metadata.<key>, not %q", field)
+			}
+			p.Same = append(p.Same, field)
+		}
+	}
+	return p, nil
+}
+
+// Filter is the query the entries around entry must match: the same value
+// of each field in Same, or no value where entry has none.
+func (p ContextParams) Filter(entry logs.Entry) QueryExpr {
+	var expr QueryExpr
+	for _, field := range p.Same {
+		if field == "service" {
+			expr = AndQuery(expr, &termExpr{Field: field, Op: "=", Value: entry.Service})
+			continue
+		}
+		v, ok := entry.Metadata[strings.TrimPrefix(field, "metadata.")]
+		if !ok {
+			expr = AndQuery(expr, &notExpr{&termExpr{Field: field, Op: "=", Kind: anyValue}})
+			continue
+		}
+		expr = AndQuery(expr, &termExpr{Field: field, Op: "=", Value: v})
+	}
+	return expr
+}
@@ -0,0 +1,63 @@
+package logquery
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/davidojo1144/LogStream/shared/logs"
+)
+
+// A stand-in for a side query over logs one every 30 minutes.
+func halfHourly(window time.Duration) ([]logs.Entry, error) {
+	return make([]logs.Entry, window/(30*time.Minute)), nil
+}
+
+func TestContextSideWidensOnlyWhenShort(t *testing.T) {
+	var searched []time.Duration
+	query := func(window time.Duration) ([]logs.Entry, error) {
+		searched = append(searched, window)
+		return halfHourly(window)
+	}
+
+	// Two entries fit in the first hour.
+	if entries, _ := ContextSide(2, query); len(entries) != 2 {
+		t.Errorf("got %d entries, want 2", len(entries))
+	}
+	if want := []time.Duration{time.Hour}; !slices.Equal(searched, want) {
+		t.Errorf("searched %v, want %v", searched, want)
+	}
+
+	// Ten need the day.
+	searched = nil
+	if entries, _ := ContextSide(10, query); len(entries) < 10 {
+		t.Errorf("got %d entries, want at least 10", len(entries))
+	}
+	if want := []time.Duration{time.Hour, 24 * time.Hour}; !slices.Equal(searched, want) {
+		t.Errorf("searched %v, want %v", searched, want)
+	}
+}
+
+func TestContextSideStopsAtWidestWindow(t *testing.T) {
+	calls := 0
+	entries, err := ContextSide(50, func(time.Duration) ([]logs.Entry, error) {
+		calls++
+		return make([]logs.Entry, 3), nil
+	})
+	if err != nil || len(entries) != 3 {
+		t.Errorf("got %d entries, %v; want the 3 from the widest window", len(entries), err)
+	}
+	if calls != len(contextWindows) {
+		t.Errorf("queried %d times, want %d", calls, len(contextWindows))
+	}
+}
+
+func TestContextSideSkipsQueryForZero(t *testing.T) {
+	entries, err := ContextSide(0, func(time.Duration) ([]logs.Entry, error) {
+		return nil, errors.New("queried")
+	})
+	if err != nil || entries == nil || len(entries) != 0 {
+		t.Errorf("ContextSide(0) = %v, %v; want an empty list without a query", entries, err)
+	}
+}
@@ -0,0 +1,123 @@
+package logquery
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/davidojo1144/LogStream/shared/logs"
+)
+
+const (
+	CursorNext = "next" // older entries
+	CursorPrev = "prev" // newer entries
+)
+
+// Cursor marks a position in a newest-first list of logs. Entries with equal
+// timestamps are ordered by ID, so a cursor stays valid while new logs
+// arrive. Clients treat it as opaque.
+type Cursor struct {
+	Timestamp time.Time `json:"t"`
+	ID        string    `json:"i"`
+	Direction string    `json:"d"`
+}
+
+func (c Cursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// LogID is the ID of an entry in /logs responses that has no stored ID: its
+// timestamp and the ID it is ordered by, so it can be found again by both.
+func LogID(timestamp time.Time, id string) string {
+	b, _ := json.Marshal(Cursor{Timestamp: timestamp, ID: id})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ParseLogID decodes an ID made by LogID into a cursor without a direction.
+func ParseLogID(s string) (*Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid log id")
+	}
+	var c Cursor
+	if err := json.Unmarshal(b, &c); err != nil || c.Timestamp.IsZero() || c.ID == "" {
+		return nil, errors.New("invalid log id")
+	}
+	return &c, nil
+}
+
+// ParseCursor decodes a cursor from next_cursor or prev_cursor. An empty
+// string returns nil.
+func ParseCursor(s string) (*Cursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	var c Cursor
+	if err := json.Unmarshal(b, &c); err != nil || c.Timestamp.IsZero() || (c.Direction != CursorNext && c.Direction != CursorPrev) {
+		return nil, errors.New("invalid cursor")
+	}
+	return &c, nil
+}
+
+// LogPage is the /logs response.
+type LogPage struct {
+	Logs       []logs.Entry `json:"logs"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+	PrevCursor string       `json:"prev_cursor,omitempty"`
+}
+
+// NewLogPage builds a page from up to limit+1 entries and their IDs, fetched
+// newest first, or oldest first when paging back with a prev cursor. The
+// extra entry only tells whether there are more.
+//
+// prev_cursor is set on every non-empty page so clients can poll for logs
+// newer than the first entry.
+func NewLogPage(entries []logs.Entry, ids []string, limit int, after *Cursor) LogPage {
+	dir := CursorNext
+	if after != nil {
+		dir = after.Direction
+	}
+	more := len(entries) > limit
+	if more {
+		entries, ids = entries[:limit], ids[:limit]
+	}
+	if dir == CursorPrev {
+		for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+			entries[i], entries[j] = entries[j], entries[i]
+			ids[i], ids[j] = ids[j], ids[i]
+		}
+	}
+
+	for i := range entries {
+		if entries[i].ID == "" {
+			entries[i].ID = LogID(entries[i].Timestamp, ids[i])
+		}
+	}
+
+	page := LogPage{Logs: entries}
+	if page.Logs == nil {
+		page.Logs = []logs.Entry{}
+	}
+	if len(entries) == 0 {
+		if after != nil {
+			page.PrevCursor = Cursor{after.Timestamp, after.ID, CursorPrev}.Encode()
+			if dir == CursorPrev {
+				page.NextCursor = Cursor{after.Timestamp, after.ID, CursorNext}.Encode()
+			}
+		}
+		return page
+	}
+
+	last := len(entries) - 1
+	page.PrevCursor = Cursor{entries[0].Timestamp, ids[0], CursorPrev}.Encode()
+	if more || dir == CursorPrev {
+		page.NextCursor = Cursor{entries[last].Timestamp, ids[last], CursorNext}.Encode()
+	}
+	return page
+}
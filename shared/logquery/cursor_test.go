@@ -0,0 +1,147 @@
+package logquery
+
+import (
+	"testing"
+	"time"
+
+	"github.com/davidojo1144/LogStream/shared/logs"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	ts := time.Date(2024, 5, 1, 10, 0, 0, 123456789, time.UTC)
+	for _, c := range []Cursor{
+		{ts, "01HX5Z3J8Q6Y1K2M3N4P5R6S7T", CursorNext},
+		{ts, "01HX5Z3J8Q6Y1K2M3N4P5R6S7T", CursorPrev},
+		{ts, "12345678901234567890", CursorNext}, // a row stored without an ID
+	} {
+		got, err := ParseCursor(c.Encode())
+		if err != nil {
+			t.Errorf("ParseCursor(%+v encoded): %v", c, err)
+			continue
+		}
+		if !got.Timestamp.Equal(c.Timestamp) || got.ID != c.ID || got.Direction != c.Direction {
+			t.Errorf("ParseCursor(%+v encoded) = %+v", c, got)
+		}
+	}
+}
+
+func TestParseCursor(t *testing.T) {
+	ts := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in      string
+		wantNil bool
+		wantErr bool
+	}{
+		{"", true, false},
+		{Cursor{ts, "x", CursorNext}.Encode(), false, false},
+		{"not base64!", false, true},
+		{"bm90IGpzb24", false, true}, // "not json"
+		{Cursor{ts, "x", "sideways"}.Encode(), false, true},
+		{Cursor{time.Time{}, "x", CursorNext}.Encode(), false, true},
+		{LogID(ts, "x"), false, true}, // no direction
+	}
+	for _, tt := range tests {
+		got, err := ParseCursor(tt.in)
+		if (err != nil) != tt.wantErr || (got == nil) != (tt.wantNil || tt.wantErr) {
+			t.Errorf("ParseCursor(%q) = %+v, %v", tt.in, got, err)
+		}
+	}
+}
+
+func TestParseLogID(t *testing.T) {
+	ts := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	c, err := ParseLogID(LogID(ts, "42"))
+	if err != nil || !c.Timestamp.Equal(ts) || c.ID != "42" || c.Direction != "" {
+		t.Errorf("ParseLogID(LogID(ts, 42)) = %+v, %v", c, err)
+	}
+	for _, in := range []string{"", "%%%", LogID(ts, ""), LogID(time.Time{}, "42")} {
+		if _, err := ParseLogID(in); err == nil {
+			t.Errorf("ParseLogID(%q) succeeded", in)
+		}
+	}
+}
+
+func TestNewLogPage(t *testing.T) {
+	base := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	// entries returns n entries newest first, all sharing one timestamp so
+	// only their IDs order them.
+	entries := func(ids ...string) ([]logs.Entry, []string) {
+		out := make([]logs.Entry, len(ids))
+		for i, id := range ids {
+			out[i] = logs.Entry{ID: id, Timestamp: base}
+		}
+		return out, append([]string(nil), ids...)
+	}
+	cursor := func(s string) *Cursor {
+		if s == "" {
+			return nil
+		}
+		c, err := ParseCursor(s)
+		if err != nil {
+			t.Fatalf("ParseCursor: %v", err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		ids      []string
+		limit    int
+		after    *Cursor
+		wantIDs  []string
+		wantPrev string // ID in prev_cursor, "" for none
+		wantNext string // ID in next_cursor, "" for none
+	}{
+		{"first page with more", []string{"E", "D", "C"}, 2, nil, []string{"E", "D"}, "E", "D"},
+		{"last page", []string{"B", "A"}, 2, &Cursor{base, "C", CursorNext}, []string{"B", "A"}, "B", ""},
+		{"back from a later page", []string{"F", "G", "H"}, 2, &Cursor{base, "E", CursorPrev}, []string{"G", "F"}, "G", "F"},
+		{"empty first page", nil, 2, nil, []string{}, "", ""},
+		{"empty poll", nil, 2, &Cursor{base, "E", CursorPrev}, []string{}, "E", "E"},
+	}
+	for _, tt := range tests {
+		list, ids := entries(tt.ids...)
+		page := NewLogPage(list, ids, tt.limit, tt.after)
+
+		var got []string
+		for _, l := range page.Logs {
+			got = append(got, l.ID)
+		}
+		if len(got) != len(tt.wantIDs) {
+			t.Errorf("%s: logs %v, want %v", tt.name, got, tt.wantIDs)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.wantIDs[i] {
+				t.Errorf("%s: logs %v, want %v", tt.name, got, tt.wantIDs)
+				break
+			}
+		}
+
+		for _, c := range []struct {
+			field, encoded, want, dir string
+		}{
+			{"prev_cursor", page.PrevCursor, tt.wantPrev, CursorPrev},
+			{"next_cursor", page.NextCursor, tt.wantNext, CursorNext},
+		} {
+			if c.want == "" {
+				if c.encoded != "" {
+					t.Errorf("%s: unexpected %s %+v", tt.name, c.field, cursor(c.encoded))
+				}
+				continue
+			}
+			got := cursor(c.encoded)
+			if got == nil || got.ID != c.want || got.Direction != c.dir || !got.Timestamp.Equal(base) {
+				t.Errorf("%s: %s = %+v, want ID %s", tt.name, c.field, got, c.want)
+			}
+		}
+	}
+}
+
+func TestNewLogPageFillsLegacyIDs(t *testing.T) {
+	ts := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	page := NewLogPage([]logs.Entry{{Timestamp: ts}}, []string{"987"}, 10, nil)
+	ref, err := ParseLogID(page.Logs[0].ID)
+	if err != nil || ref.ID != "987" || !ref.Timestamp.Equal(ts) {
+		t.Errorf("ID %q decodes to %+v, %v", page.Logs[0].ID, ref, err)
+	}
+}
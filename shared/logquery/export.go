@@ -0,0 +1,201 @@
+package logquery
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/davidojo1144/LogStream/shared/auth"
+	"github.com/davidojo1144/LogStream/shared/logs"
+	"github.com/davidojo1144/LogStream/shared/metrics"
+	"github.com/parquet-go/parquet-go"
+)
+
+const (
+	// exportFlushRows is how many rows are written between flushes of the
+	// response, so clients see progress and nothing piles up in buffers.
+	ExportFlushRows = 1000
+	// exportRowGroupRows bounds the rows a Parquet export holds in memory
+	// before writing them out as a row group.
+	exportRowGroupRows = 10000
+)
+
+// ExportConfig caps how many rows one /export returns. Keys with the admin
+// scope get the higher cap; read keys and web app sessions the lower one.
+type ExportConfig struct {
+	MaxRows      int64 `yaml:"max_rows" env:"EXPORT_MAX_ROWS" flag:"export-max-rows" usage:"Most rows one /export returns to read-scoped API keys and web app sessions"`
+	AdminMaxRows int64 `yaml:"admin_max_rows" env:"EXPORT_ADMIN_MAX_ROWS" flag:"export-admin-max-rows" usage:"Most rows one /export returns to admin-scoped API keys"`
+}
+
+func (c ExportConfig) Validate() []error {
+	if c.MaxRows <= 0 || c.AdminMaxRows <= 0 {
+		return []error{errors.New("export.max_rows and export.admin_max_rows must be positive")}
+	}
+	return nil
+}
+
+// RowCap is the most rows key may export.
+func (c ExportConfig) RowCap(key *auth.ApiKey) int64 {
+	if key != nil && key.HasScope(auth.ScopeAdmin) {
+		return c.AdminMaxRows
+	}
+	return c.MaxRows
+}
+
+// ExportWriter writes log entries in one export format.
+type ExportWriter interface {
+	Write(l logs.Entry) error
+	// Close writes whatever the format needs at the end, such as the
+	// Parquet footer. It does not close the underlying writer.
+	Close() error
+}
+
+// exportFormats maps the format parameter to the content type and file
+// extension of each format.
+var exportFormats = map[string]struct{ contentType, ext string }{
+	"ndjson":  {"application/x-ndjson", "ndjson"},
+	"csv":     {"text/csv; charset=utf-8", "csv"},
+	"parquet": {"application/vnd.apache.parquet", "parquet"},
+}
+
+// ParseExportFormat reads the format parameter, ndjson by default.
+func ParseExportFormat(format string) (string, error) {
+	if format == "" {
+		return "ndjson", nil
+	}
+	if _, ok := exportFormats[format]; !ok {
+		return "", fmt.Errorf("format must be ndjson, csv or parquet")
+	}
+	return format, nil
+}
+
+// Export streams logs to w in format, at most limit of them. next is called
+// for each log, and is handed a function to write it. Headers are sent before
+// the first row; if more than limit rows match, the X-Export-Truncated
+// trailer is set to true.
+func Export(w http.ResponseWriter, format string, limit int64, next func(write func(logs.Entry) error) error) error {
+	f := exportFormats[format]
+	w.Header().Set("Content-Type", f.contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="logs-%s.%s"`, time.Now().UTC().Format("20060102T150405Z"), f.ext))
+	w.Header().Set("X-Export-Row-Cap", strconv.FormatInt(limit, 10))
+	w.Header().Set("Trailer", "X-Export-Truncated")
+	w.WriteHeader(http.StatusOK)
+
+	var ew ExportWriter
+	switch format {
+	case "csv":
+		ew = newCSVExport(w)
+	case "parquet":
+		ew = newParquetExport(w)
+	default:
+		ew = &ndjsonExport{enc: json.NewEncoder(w)}
+	}
+	flusher, _ := w.(http.Flusher)
+
+	var rows int64
+	truncated := false
+	err := next(func(l logs.Entry) error {
+		if rows == limit {
+			truncated = true
+			return errExportCap
+		}
+		if err := ew.Write(l); err != nil {
+			return err
+		}
+		rows++
+		if rows%ExportFlushRows == 0 && flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if errors.Is(err, errExportCap) {
+		err = nil
+	}
+	if closeErr := ew.Close(); err == nil {
+		err = closeErr
+	}
+	w.Header().Set("X-Export-Truncated", strconv.FormatBool(truncated))
+	metrics.ExportRows.WithLabelValues(format).Add(float64(rows))
+	return err
+}
+
+// errExportCap stops the query once the row cap is reached.
+var errExportCap = errors.New("export row cap reached")
+
+type ndjsonExport struct {
+	enc *json.Encoder
+}
+
+func (e *ndjsonExport) Write(l logs.Entry) error { return e.enc.Encode(l) }
+func (e *ndjsonExport) Close() error             { return nil }
+
+// csvExport writes one row per log with the metadata as a JSON object.
+type csvExport struct {
+	w *csv.Writer
+}
+
+var csvExportHeader = []string{"id", "timestamp", "service", "level", "raw_level", "severity", "message", "metadata"}
+
+func newCSVExport(w io.Writer) *csvExport {
+	e := &csvExport{w: csv.NewWriter(w)}
+	e.w.Write(csvExportHeader)
+	return e
+}
+
+func (e *csvExport) Write(l logs.Entry) error {
+	metadata := ""
+	if len(l.Metadata) > 0 {
+		b, _ := json.Marshal(l.Metadata)
+		metadata = string(b)
+	}
+	return e.w.Write([]string{l.ID, l.Timestamp.UTC().Format(time.RFC3339Nano), l.Service, l.Level, l.RawLevel, strconv.Itoa(l.Severity), l.Message, metadata})
+}
+
+func (e *csvExport) Close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// parquetRow is the Parquet schema of an export.
+type parquetRow struct {
+	ID        string            `parquet:"id"`
+	Timestamp time.Time         `parquet:"timestamp,timestamp(millisecond)"`
+	Service   string            `parquet:"service,dict"`
+	Level     string            `parquet:"level,dict"`
+	RawLevel  string            `parquet:"raw_level,dict"`
+	Severity  int32             `parquet:"severity"`
+	Message   string            `parquet:"message"`
+	Metadata  map[string]string `parquet:"metadata"`
+}
+
+type parquetExport struct {
+	w *parquet.GenericWriter[parquetRow]
+}
+
+func newParquetExport(w io.Writer) *parquetExport {
+	return &parquetExport{w: parquet.NewGenericWriter[parquetRow](w,
+		parquet.Compression(&parquet.Zstd),
+		parquet.MaxRowsPerRowGroup(exportRowGroupRows),
+	)}
+}
+
+func (e *parquetExport) Write(l logs.Entry) error {
+	_, err := e.w.Write([]parquetRow{{
+		ID:        l.ID,
+		Timestamp: l.Timestamp,
+		Service:   l.Service,
+		Level:     l.Level,
+		RawLevel:  l.RawLevel,
+		Severity:  int32(l.Severity),
+		Message:   l.Message,
+		Metadata:  l.Metadata,
+	}})
+	return err
+}
+
+func (e *parquetExport) Close() error { return e.w.Close() }
@@ -0,0 +1,111 @@
+package logquery
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultFacetTop = 10
+	maxFacetTop     = 100
+	maxFacetFields  = 10
+)
+
+// FacetValue is one value of a field and how many matching logs have it.
+type FacetValue struct {
+	Value string `json:"value"`
+	Count uint64 `json:"count"`
+}
+
+// Facet is the most common values of a field among the logs matching a
+// query. Count is how many of those logs have the field, and Cardinality how
+// many distinct values it takes; ClickHouse estimates both the top values
+// and the cardinality.
+type Facet struct {
+	Field       string       `json:"field"`
+	Count       uint64       `json:"count"`
+	Cardinality uint64       `json:"cardinality"`
+	Values      []FacetValue `json:"values"`
+}
+
+// FacetParams are the options of /facets.
+type FacetParams struct {
+	Fields []string // service, level or metadata.<key>
+	Top    int
+}
+
+// ParseFacetParams reads fields, a comma-separated list of service, level
+// and metadata.<key> (default service,level), and top, the number of values
+// to return per field.
+func ParseFacetParams(query url.Values) (FacetParams, error) {
+	p := FacetParams{Fields: []string{"service", "level"}, Top: defaultFacetTop}
+
+	if f := query.Get("fields"); f != "" {
+		p.Fields = nil
+		seen := make(map[string]bool)
+		for _, field := range strings.Split(f, ",") {
+			field = strings.TrimSpace(field)
+			switch {
+			case field == "service", field == "level":
+			case strings.HasPrefix(field, "metadata.") && len(field) > len("metadata."):
+			default:
+				return p, fmt.Errorf("facet fields must be service, level or metadata.<key>, not %q", field)
+			}
+			if !seen[field] {
+				seen[field] = true
+				p.Fields = append(p.Fields, field)
+			}
+		}
+		if len(p.Fields) > maxFacetFields {
+			return p, fmt.Errorf("at most %d facet fields are allowed", maxFacetFields)
+		}
+	}
+
+	if t := query.Get("top"); t != "" {
+		n, err := strconv.Atoi(t)
+		if err != nil || n < 1 || n > maxFacetTop {
+			return p, fmt.Errorf("top must be between 1 and %d", maxFacetTop)
+		}
+		p.Top = n
+	}
+	return p, nil
+}
+
+// FacetColumn returns the expression for a facet field, the condition for a
+// log to have it, or "" when every log has it, and their arguments, numbered
+// from firstArg+1 for Postgres.
+func FacetColumn(dialect Dialect, firstArg int, field string) (value, has string, args []interface{}) {
+	c := &sqlCompiler{dialect: dialect, next: firstArg}
+	value, has = facetSQL(c, field)
+	return value, has, c.args
+}
+
+// facetSQL returns the expression for a facet field and the condition for a
+// log to have it, or "" when every log has it.
+func facetSQL(c *sqlCompiler, field string) (value, has string) {
+	key, isMetadata := strings.CutPrefix(field, "metadata.")
+	switch {
+	case field == "level" && c.dialect == ClickHouse:
+		return "toString(severity)", ""
+	case field == "level":
+		return "severity::text", ""
+	case !isMetadata:
+		return field, ""
+	case c.dialect == ClickHouse:
+		return "metadata[" + c.arg(key) + "]", "mapContains(metadata, " + c.arg(key) + ")"
+	}
+	// The key is used twice under one placeholder.
+	k := c.arg(key)
+	return "COALESCE(metadata->>" + k + ", '')", "metadata ? " + k
+}
+
+// FacetLabel is how a value of field is reported: levels are counted by
+// severity and reported by name.
+func FacetLabel(field, v string) string {
+	if field == "level" {
+		return severityName(v)
+	}
+	return v
+}
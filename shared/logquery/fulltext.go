@@ -0,0 +1,202 @@
+package logquery
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/davidojo1144/LogStream/shared/logs"
+)
+
+// Message search is by word rather than substring, so that it can use the
+// full-text indexes: a tokenbf_v1 index on lower(message) and an ngrambf_v1
+// index for prefixes in ClickHouse (init.sql), and a GIN index on
+// messageVector in Postgres (migration.sql). The SQL below must use exactly
+// those expressions for the indexes to apply.
+//
+//	timeout      the word timeout
+//	user_123     the words user and 123, next to each other
+//	"conn reset" the phrase
+//	time*        a word starting with time
+
+// messageVector indexes the same tokens as textTokens in Postgres. Left to
+// itself, the text search parser would keep hostnames, paths, URLs and email
+// addresses whole, so everything but ASCII letters and digits and non-ASCII
+// characters is replaced by spaces first.
+const messageVector = `to_tsvector('simple', regexp_replace(message, '[^0-9A-Za-z\u0080-\U0010FFFF]+', ' ', 'g'))`
+
+// textTokens splits s into the tokens ClickHouse indexes: runs of ASCII
+// letters and digits, and of non-ASCII bytes. ASCII letters are lower-cased,
+// as lower() does.
+func textTokens(s string) []string {
+	var tokens []string
+	start := -1
+	for i := 0; i <= len(s); i++ {
+		if i < len(s) && isTokenByte(s[i]) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			tokens = append(tokens, asciiLower(s[start:i]))
+			start = -1
+		}
+	}
+	return tokens
+}
+
+func isTokenByte(b byte) bool {
+	return b >= utf8.RuneSelf || 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z' || '0' <= b && b <= '9'
+}
+
+func asciiLower(s string) string {
+	return strings.Map(func(r rune) rune {
+		if 'A' <= r && r <= 'Z' {
+			return r + 'a' - 'A'
+		}
+		return r
+	}, s)
+}
+
+// wordPattern is a regular expression for value as whole words: a word
+// boundary is required on each side that is a word character. With prefix,
+// the last word may continue.
+func wordPattern(value string, prefix bool) string {
+	p := regexp.QuoteMeta(value)
+	if first, _ := utf8.DecodeRuneInString(value); isWordRune(first) {
+		p = `\b` + p
+	}
+	if prefix {
+		return p + `\w*`
+	}
+	if last, _ := utf8.DecodeLastRuneInString(value); isWordRune(last) {
+		p += `\b`
+	}
+	return p
+}
+
+func isWordRune(r rune) bool {
+	return r < utf8.RuneSelf && (r == '_' || isTokenByte(byte(r)))
+}
+
+// text compiles a word, phrase or prefix search on the message.
+func (c *sqlCompiler) text(value string, kind valueKind) string {
+	tokens := textTokens(value)
+	if len(tokens) == 0 {
+		// Only punctuation, which no index covers.
+		if c.dialect == ClickHouse {
+			return "positionCaseInsensitive(message, " + c.arg(value) + ") > 0"
+		}
+		return "message ILIKE " + c.arg("%"+escapeLike(value)+"%")
+	}
+	prefix := kind == prefixValue
+
+	if c.dialect == Postgres {
+		if !prefix {
+			return messageVector + " @@ phraseto_tsquery('simple', " + c.arg(strings.Join(tokens, " ")) + ")"
+		}
+		lexemes := make([]string, len(tokens))
+		for i, t := range tokens {
+			lexemes[i] = "'" + strings.ToLower(t) + "'"
+		}
+		lexemes[len(lexemes)-1] += ":*"
+		return messageVector + " @@ to_tsquery('simple', " + c.arg(strings.Join(lexemes, " <-> ")) + ")"
+	}
+
+	// hasToken finds whole tokens through the token index. A prefix is
+	// found through the ngram index instead. The regular expression then
+	// checks that the words are adjacent and in order.
+	var conds []string
+	exact := tokens
+	if prefix {
+		exact = tokens[:len(tokens)-1]
+	}
+	for _, t := range exact {
+		conds = append(conds, "hasToken(lower(message), "+c.arg(t)+")")
+	}
+	if prefix {
+		conds = append(conds, "lower(message) LIKE "+c.arg("%"+escapeLike(asciiLower(value))+"%"))
+	}
+	if prefix || len(tokens) > 1 || tokens[0] != asciiLower(value) {
+		conds = append(conds, "match(message, "+c.arg("(?i)"+wordPattern(value, prefix))+")")
+	}
+	return strings.Join(conds, " AND ")
+}
+
+// SearchQuery turns the search parameter into a query: each word must be in
+// the message, and a trailing * makes it a prefix. It returns nil for an
+// empty search.
+func SearchQuery(search string) QueryExpr {
+	var expr QueryExpr
+	for _, word := range strings.Fields(search) {
+		t := &termExpr{Field: "message", Op: "=", Value: word}
+		if strings.HasSuffix(word, "*") && len(word) > 1 {
+			t.Value, t.Kind = strings.TrimSuffix(word, "*"), prefixValue
+		}
+		expr = AndQuery(expr, t)
+	}
+	return expr
+}
+
+// Highlight sets the Highlights of each entry to where its message matches
+// the words, phrases, prefixes and regular expressions the query searched
+// for. Terms under NOT are not highlighted.
+func Highlight(logs []logs.Entry, expr QueryExpr) {
+	var patterns []string
+	highlightPatterns(expr, false, &patterns)
+	if len(patterns) == 0 {
+		return
+	}
+	re, err := regexp.Compile("(?i)" + strings.Join(patterns, "|"))
+	if err != nil {
+		return
+	}
+
+	for i := range logs {
+		msg := logs[i].Message
+		for _, m := range re.FindAllStringIndex(msg, -1) {
+			if m[0] == m[1] {
+				continue
+			}
+			start := utf16Len(msg[:m[0]])
+			logs[i].Highlights = append(logs[i].Highlights, [2]int{start, start + utf16Len(msg[m[0]:m[1]])})
+		}
+	}
+}
+
+func highlightPatterns(expr QueryExpr, negated bool, out *[]string) {
+	switch e := expr.(type) {
+	case *andExpr:
+		highlightPatterns(e.left, negated, out)
+		highlightPatterns(e.right, negated, out)
+	case *orExpr:
+		highlightPatterns(e.left, negated, out)
+		highlightPatterns(e.right, negated, out)
+	case *notExpr:
+		highlightPatterns(e.x, !negated, out)
+	case *termExpr:
+		if negated || e.Op == "!=" || e.Field != "message" {
+			return
+		}
+		if e.Kind == regexValue {
+			*out = append(*out, "(?-i:"+e.Value+")")
+		} else {
+			*out = append(*out, "(?:"+wordPattern(e.Value, e.Kind == prefixValue)+")")
+		}
+	}
+}
+
+// utf16Len is the length of s in UTF-16 code units, the unit JavaScript
+// string offsets are in.
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		if r >= 0x10000 {
+			n += 2
+		} else {
+			n++
+		}
+	}
+	return n
+}
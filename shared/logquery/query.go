@@ -0,0 +1,629 @@
+// Package logquery parses the search language and the parameters of the
+// read endpoints, and compiles them to ClickHouse and Postgres SQL.
+package logquery
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/davidojo1144/LogStream/shared/logs"
+)
+
+// The search language accepted in the q parameter of /logs and /stats:
+//
+//	checkout timeout                   both words in the message (AND is implicit)
+//	"connection reset"                 phrase in the message
+//	check*                             a word in the message starting with check
+//	/time(d)? ?out/                    regular expression on the message
+//	service:checkout                   exact field match
+//	level:error, level:17              level by name (any alias) or severity number
+//	service:check*                     prefix match
+//	level:>=warn                       severity comparison (also >, <, <= and ranges)
+//	metadata.status>=500               metadata comparison, numeric when the value is a number
+//	metadata.region:[eu-1 TO eu-3]     inclusive range
+//	metadata.user_id:*                 metadata key exists
+//	timestamp>="2024-05-01T10:00:00Z"  time comparison (RFC3339 or YYYY-MM-DD)
+//	a OR b, NOT a, -a, (a OR b) AND c  boolean operators; AND binds tighter than OR
+//
+// A query is parsed once into a QueryExpr and compiled to ClickHouse or
+// Postgres SQL. All validation happens while parsing, so compiling cannot
+// fail.
+
+// QueryExpr is a parsed search query.
+type QueryExpr interface {
+	compile(c *sqlCompiler) string
+}
+
+type andExpr struct{ left, right QueryExpr }
+type orExpr struct{ left, right QueryExpr }
+type notExpr struct{ x QueryExpr }
+
+type valueKind int
+
+const (
+	plainValue valueKind = iota
+	phraseValue
+	regexValue
+	prefixValue
+	anyValue // field:*, only for metadata keys
+)
+
+// termExpr is a single condition. Field is "message" for free text.
+type termExpr struct {
+	Field string // service, level, message, timestamp or metadata.<key>
+	Op    string // =, !=, >, >=, <, <= or range
+	Value string
+	High  string // upper bound of a range
+	Kind  valueKind
+}
+
+// QueryError reports a malformed query and where in it the problem is.
+type QueryError struct {
+	Pos int
+	Msg string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("invalid query at position %d: %s", e.Pos+1, e.Msg)
+}
+
+// ParseQuery parses a search query. An empty query returns nil.
+func ParseQuery(input string) (QueryExpr, error) {
+	p := &queryParser{in: input}
+	p.skipSpace()
+	if p.eof() {
+		return nil, nil
+	}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if !p.eof() {
+		return nil, p.errorf("unexpected %q", p.peek())
+	}
+	return expr, nil
+}
+
+type queryParser struct {
+	in  string
+	pos int
+}
+
+func (p *queryParser) eof() bool { return p.pos >= len(p.in) }
+
+// peek returns the character at the current position, for error messages.
+func (p *queryParser) peek() rune {
+	r, _ := utf8.DecodeRuneInString(p.in[p.pos:])
+	return r
+}
+
+func (p *queryParser) errorf(format string, args ...any) error {
+	return &QueryError{Pos: p.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *queryParser) skipSpace() {
+	for !p.eof() && isSpace(p.in[p.pos]) {
+		p.pos++
+	}
+}
+
+// keyword consumes an upper-case operator such as AND when it stands alone.
+func (p *queryParser) keyword(kw string) bool {
+	p.skipSpace()
+	end := p.pos + len(kw)
+	if end > len(p.in) || p.in[p.pos:end] != kw {
+		return false
+	}
+	if end < len(p.in) && !isSpace(p.in[end]) && p.in[end] != '(' {
+		return false
+	}
+	p.pos = end
+	return true
+}
+
+func (p *queryParser) parseOr() (QueryExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orExpr{left, right}
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseAnd() (QueryExpr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		p.skipSpace()
+		if p.eof() || p.in[p.pos] == ')' {
+			return left, nil
+		}
+		start := p.pos
+		if p.keyword("OR") {
+			p.pos = start
+			return left, nil
+		}
+		p.keyword("AND")
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &andExpr{left, right}
+	}
+}
+
+func (p *queryParser) parseUnary() (QueryExpr, error) {
+	p.skipSpace()
+	if p.keyword("NOT") {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notExpr{x}, nil
+	}
+	if p.pos+1 < len(p.in) && p.in[p.pos] == '-' && !isSpace(p.in[p.pos+1]) {
+		p.pos++
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notExpr{x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *queryParser) parsePrimary() (QueryExpr, error) {
+	p.skipSpace()
+	if p.eof() {
+		return nil, p.errorf("expected a search term")
+	}
+
+	switch p.in[p.pos] {
+	case '(':
+		p.pos++
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if p.eof() || p.in[p.pos] != ')' {
+			return nil, p.errorf("missing )")
+		}
+		p.pos++
+		return expr, nil
+	case ')':
+		return nil, p.errorf("unexpected )")
+	case '"', '/':
+		start := p.pos
+		value, kind, err := p.parseQuoted()
+		if err != nil {
+			return nil, err
+		}
+		return p.term(start, &termExpr{Field: "message", Op: "=", Value: value, Kind: kind})
+	}
+
+	start := p.pos
+	word := p.readWhile(func(c byte) bool { return !isSpaceOrParen(c) && !isOperator(c) })
+	if word == "" {
+		return nil, p.errorf("unexpected %q", p.peek())
+	}
+	if p.eof() || !isOperator(p.in[p.pos]) {
+		t := &termExpr{Field: "message", Op: "=", Value: word}
+		if strings.HasSuffix(word, "*") {
+			t.Value, t.Kind = strings.TrimSuffix(word, "*"), prefixValue
+		}
+		return p.term(start, t)
+	}
+
+	t := &termExpr{Field: word, Op: p.parseOperator()}
+	if t.Op == "" {
+		return nil, p.errorf("expected an operator after %s", word)
+	}
+
+	switch {
+	case p.eof() || isSpaceOrParen(p.in[p.pos]):
+		return nil, p.errorf("expected a value for %s", word)
+	case p.in[p.pos] == '"' || p.in[p.pos] == '/':
+		value, kind, err := p.parseQuoted()
+		if err != nil {
+			return nil, err
+		}
+		t.Value, t.Kind = value, kind
+	case p.in[p.pos] == '[' && t.Op == "=":
+		if err := p.parseRange(t); err != nil {
+			return nil, err
+		}
+	default:
+		t.Value = p.readWhile(func(c byte) bool { return !isSpaceOrParen(c) })
+		if strings.HasSuffix(t.Value, "*") && (t.Op == "=" || t.Op == "!=") {
+			t.Value, t.Kind = strings.TrimSuffix(t.Value, "*"), prefixValue
+		}
+	}
+	return p.term(start, t)
+}
+
+// parseOperator reads =, !=, <, <=, >, >= or a colon optionally followed by
+// a comparison, as in level:>=warn.
+func (p *queryParser) parseOperator() string {
+	op := ""
+	if p.in[p.pos] == ':' {
+		p.pos++
+		op = "="
+	}
+	for _, cmp := range []string{"!=", ">=", "<=", ">", "<", "="} {
+		if strings.HasPrefix(p.in[p.pos:], cmp) {
+			p.pos += len(cmp)
+			return cmp
+		}
+	}
+	return op
+}
+
+// parseQuoted reads a "phrase" or a /regex/. Backslash escapes the delimiter.
+func (p *queryParser) parseQuoted() (string, valueKind, error) {
+	delim := p.in[p.pos]
+	start := p.pos
+	p.pos++
+
+	var b strings.Builder
+	for !p.eof() && p.in[p.pos] != delim {
+		if p.in[p.pos] == '\\' && p.pos+1 < len(p.in) && p.in[p.pos+1] == delim {
+			p.pos++
+		}
+		b.WriteByte(p.in[p.pos])
+		p.pos++
+	}
+	if p.eof() {
+		p.pos = start
+		return "", 0, p.errorf("unterminated %c", delim)
+	}
+	p.pos++
+
+	if delim == '/' {
+		return b.String(), regexValue, nil
+	}
+	return b.String(), phraseValue, nil
+}
+
+// parseRange reads [low TO high].
+func (p *queryParser) parseRange(t *termExpr) error {
+	p.pos++
+	p.skipSpace()
+	low := p.readWhile(func(c byte) bool { return !isSpace(c) && c != ']' })
+	if !p.keyword("TO") {
+		return p.errorf("expected TO in range")
+	}
+	p.skipSpace()
+	high := p.readWhile(func(c byte) bool { return !isSpace(c) && c != ']' })
+	p.skipSpace()
+	if p.eof() || p.in[p.pos] != ']' || low == "" || high == "" {
+		return p.errorf("expected [low TO high]")
+	}
+	p.pos++
+	t.Op, t.Value, t.High = "range", strings.Trim(low, `"`), strings.Trim(high, `"`)
+	return nil
+}
+
+func (p *queryParser) readWhile(ok func(byte) bool) string {
+	start := p.pos
+	for !p.eof() && ok(p.in[p.pos]) {
+		p.pos++
+	}
+	return p.in[start:p.pos]
+}
+
+// isSpace reports whether c is ASCII whitespace. The parser reads bytes, and
+// only ASCII whitespace separates terms: every byte of a multi-byte UTF-8
+// character is above 0x7F, so words like "voilà" are never split.
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+func isSpaceOrParen(c byte) bool {
+	return isSpace(c) || c == '(' || c == ')'
+}
+
+func isOperator(c byte) bool {
+	return c == ':' || c == '=' || c == '!' || c == '<' || c == '>'
+}
+
+// term validates a condition so that compiling it cannot fail.
+func (p *queryParser) term(start int, t *termExpr) (QueryExpr, error) {
+	fail := func(format string, args ...any) (QueryExpr, error) {
+		return nil, &QueryError{Pos: start, Msg: fmt.Sprintf(format, args...)}
+	}
+
+	if t.Kind == regexValue {
+		if t.Op != "=" && t.Op != "!=" {
+			return fail("regular expressions only support : and !=")
+		}
+		if _, err := regexp.Compile(t.Value); err != nil {
+			return fail("bad regular expression: %v", err)
+		}
+		if _, err := postgresRegex(t.Value); err != nil {
+			return fail("bad regular expression: %v", err)
+		}
+	}
+	if t.Kind == plainValue && t.Op != "range" && t.Value == "" {
+		return fail("empty value")
+	}
+	comparison := t.Op != "=" && t.Op != "!="
+
+	switch {
+	case t.Field == "service":
+		if comparison {
+			return fail("service only supports :, = and !=")
+		}
+	case t.Field == "message":
+		if comparison {
+			return fail("message only supports :, = and !=")
+		}
+		if t.Value == "" {
+			return fail("empty value")
+		}
+	case t.Field == "level":
+		if t.Kind == plainValue || t.Kind == phraseValue {
+			for _, v := range []string{t.Value, t.High} {
+				if _, ok := logs.ParseSeverity(v); v != "" && !ok {
+					return fail("unknown level %q", v)
+				}
+			}
+		}
+	case t.Field == "timestamp":
+		if !comparison || t.Kind != plainValue && t.Kind != phraseValue {
+			return fail("timestamp only supports <, <=, >, >= and ranges")
+		}
+		for _, v := range []string{t.Value, t.High} {
+			if _, err := parseQueryTime(v); v != "" && err != nil {
+				return fail("bad time %q, use RFC3339 or YYYY-MM-DD", v)
+			}
+		}
+	case strings.HasPrefix(t.Field, "metadata."):
+		if len(t.Field) == len("metadata.") {
+			return fail("missing metadata key")
+		}
+		if t.Kind == prefixValue && t.Value == "" {
+			t.Kind = anyValue
+		}
+	default:
+		return fail("unknown field %q", t.Field)
+	}
+	return t, nil
+}
+
+func parseQueryTime(v string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("bad time %q", v)
+}
+
+// Dialect selects the SQL flavour a query compiles to.
+type Dialect int
+
+const (
+	ClickHouse Dialect = iota
+	Postgres
+)
+
+// CompileQuery turns a parsed query into a SQL condition and its arguments.
+// Postgres placeholders are numbered from firstArg.
+func CompileQuery(expr QueryExpr, dialect Dialect, firstArg int) (string, []interface{}) {
+	c := &sqlCompiler{dialect: dialect, next: firstArg}
+	return expr.compile(c), c.args
+}
+
+type sqlCompiler struct {
+	dialect Dialect
+	next    int
+	args    []interface{}
+}
+
+// arg adds a query argument and returns its placeholder.
+func (c *sqlCompiler) arg(v interface{}) string {
+	c.args = append(c.args, v)
+	if c.dialect == ClickHouse {
+		return "?"
+	}
+	c.next++
+	return "$" + strconv.Itoa(c.next-1)
+}
+
+func (e *andExpr) compile(c *sqlCompiler) string {
+	return "(" + e.left.compile(c) + " AND " + e.right.compile(c) + ")"
+}
+
+func (e *orExpr) compile(c *sqlCompiler) string {
+	return "(" + e.left.compile(c) + " OR " + e.right.compile(c) + ")"
+}
+
+func (e *notExpr) compile(c *sqlCompiler) string {
+	return "NOT " + e.x.compile(c)
+}
+
+func (t *termExpr) compile(c *sqlCompiler) string {
+	cond := t.condition(c)
+	if t.Op == "!=" {
+		return "NOT (" + cond + ")"
+	}
+	return "(" + cond + ")"
+}
+
+// condition compiles the term as if its operator were = when it is !=.
+func (t *termExpr) condition(c *sqlCompiler) string {
+	switch {
+	case t.Field == "message":
+		if t.Kind == regexValue {
+			return c.match("message", t.Value, t.Kind)
+		}
+		return c.text(t.Value, t.Kind)
+	case t.Field == "timestamp":
+		low, _ := parseQueryTime(t.Value)
+		if t.Op == "range" {
+			high, _ := parseQueryTime(t.High)
+			return "timestamp >= " + c.arg(low) + " AND timestamp <= " + c.arg(high)
+		}
+		return "timestamp " + t.Op + " " + c.arg(low)
+	case t.Field == "level" && (t.Kind == regexValue || t.Kind == prefixValue):
+		return c.match("level", t.Value, t.Kind)
+	case t.Field == "level":
+		low, _ := logs.ParseSeverity(t.Value)
+		if t.Op == "range" {
+			high, _ := logs.ParseSeverity(t.High)
+			return "severity >= " + c.arg(low) + " AND severity <= " + c.arg(high)
+		}
+		op := t.Op
+		if op == "!=" {
+			op = "=" // negated by compile
+		}
+		return "severity " + op + " " + c.arg(low)
+	case t.Field == "service":
+		return c.match("service", t.Value, t.Kind)
+	}
+
+	key := strings.TrimPrefix(t.Field, "metadata.")
+	switch {
+	case t.Kind == anyValue:
+		return c.metadataExists(key)
+	case (t.Op == "=" || t.Op == "!=") && (t.Kind == plainValue || t.Kind == phraseValue):
+		return c.metadataEquals(key, t.Value)
+	case t.Op == "=" || t.Op == "!=":
+		return c.match(c.metadata(key), t.Value, t.Kind)
+	case t.Op == "range" && isNumber(t.Value) && isNumber(t.High):
+		low := c.metadataNumber(key) + " >= " + c.arg(toNumber(t.Value))
+		return low + " AND " + c.metadataNumber(key) + " <= " + c.arg(toNumber(t.High))
+	case t.Op == "range":
+		low := c.metadata(key) + " >= " + c.arg(t.Value)
+		return low + " AND " + c.metadata(key) + " <= " + c.arg(t.High)
+	case isNumber(t.Value):
+		return c.metadataNumber(key) + " " + t.Op + " " + c.arg(toNumber(t.Value))
+	}
+	return c.metadata(key) + " " + t.Op + " " + c.arg(t.Value)
+}
+
+// match compiles an equality, prefix or regex test on col.
+func (c *sqlCompiler) match(col, value string, kind valueKind) string {
+	switch {
+	case kind == regexValue && c.dialect == ClickHouse:
+		return "match(" + col + ", " + c.arg(value) + ")"
+	case kind == regexValue:
+		re, _ := postgresRegex(value)
+		return col + " ~ " + c.arg(re)
+	case kind == prefixValue && c.dialect == ClickHouse:
+		return "startsWith(" + col + ", " + c.arg(value) + ")"
+	case kind == prefixValue:
+		return col + " LIKE " + c.arg(escapeLike(value)+"%")
+	}
+	return col + " = " + c.arg(value)
+}
+
+func (c *sqlCompiler) metadata(key string) string {
+	if c.dialect == ClickHouse {
+		return "metadata[" + c.arg(key) + "]"
+	}
+	return "(metadata->>" + c.arg(key) + ")"
+}
+
+// metadataExists and metadataEquals are written so that the ClickHouse bloom
+// filter indexes on the map keys and values, and the Postgres GIN index on
+// the JSONB column, can skip rows.
+func (c *sqlCompiler) metadataExists(key string) string {
+	if c.dialect == ClickHouse {
+		return "mapContains(metadata, " + c.arg(key) + ")"
+	}
+	return "metadata ? " + c.arg(key)
+}
+
+func (c *sqlCompiler) metadataEquals(key, value string) string {
+	if c.dialect == ClickHouse {
+		return "metadata[" + c.arg(key) + "] = " + c.arg(value)
+	}
+	return "metadata @> jsonb_build_object(" + c.arg(key) + "::text, " + c.arg(value) + "::text)"
+}
+
+// metadataNumber is the metadata value as a number, NULL when it is not one.
+func (c *sqlCompiler) metadataNumber(key string) string {
+	if c.dialect == ClickHouse {
+		return "toFloat64OrNull(metadata[" + c.arg(key) + "])"
+	}
+	col := c.metadata(key)
+	return `(CASE WHEN ` + col + ` ~ '^-?[0-9]+(\.[0-9]+)?$' THEN ` + col + `::numeric END)`
+}
+
+// MetadataFilter turns metadata.<key> URL parameters into a query: a single
+// value must match exactly, repeated values match any of them, and * matches
+// any entry that has the key. It returns nil when there are none.
+func MetadataFilter(params url.Values) (QueryExpr, error) {
+	var keys []string
+	for name := range params {
+		if strings.HasPrefix(name, "metadata.") {
+			keys = append(keys, name)
+		}
+	}
+	sort.Strings(keys)
+
+	var filter QueryExpr
+	for _, name := range keys {
+		if name == "metadata." {
+			return nil, fmt.Errorf("missing metadata key in parameter %q", name)
+		}
+		var match QueryExpr
+		for _, value := range params[name] {
+			t := &termExpr{Field: name, Op: "=", Value: value, Kind: phraseValue}
+			if value == "*" {
+				t.Kind = anyValue
+			}
+			match = joinQuery(match, t, false)
+		}
+		filter = joinQuery(filter, match, true)
+	}
+	return filter, nil
+}
+
+// AndQuery combines two optional queries.
+func AndQuery(a, b QueryExpr) QueryExpr {
+	return joinQuery(a, b, true)
+}
+
+func joinQuery(a, b QueryExpr, and bool) QueryExpr {
+	switch {
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	case and:
+		return &andExpr{a, b}
+	}
+	return &orExpr{a, b}
+}
+
+func isNumber(s string) bool {
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil
+}
+
+func toNumber(s string) float64 {
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
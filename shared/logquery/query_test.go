@@ -0,0 +1,123 @@
+package logquery
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		in   string
+		want QueryExpr
+	}{
+		{"", nil},
+		{"   ", nil},
+		{"timeout", &termExpr{Field: "message", Op: "=", Value: "timeout"}},
+		{"voilà", &termExpr{Field: "message", Op: "=", Value: "voilà"}},
+		{"Рост Ѕ", &andExpr{
+			&termExpr{Field: "message", Op: "=", Value: "Рост"},
+			&termExpr{Field: "message", Op: "=", Value: "Ѕ"},
+		}},
+		{"service:café", &termExpr{Field: "service", Op: "=", Value: "café"}},
+		{`"connection reset"`, &termExpr{Field: "message", Op: "=", Value: "connection reset", Kind: phraseValue}},
+		{`/time(d)? ?out/`, &termExpr{Field: "message", Op: "=", Value: "time(d)? ?out", Kind: regexValue}},
+		{"check*", &termExpr{Field: "message", Op: "=", Value: "check", Kind: prefixValue}},
+		{"level:>=warn", &termExpr{Field: "level", Op: ">=", Value: "warn"}},
+		{"metadata.status!=500", &termExpr{Field: "metadata.status", Op: "!=", Value: "500"}},
+		{"metadata.user_id:*", &termExpr{Field: "metadata.user_id", Op: "=", Kind: anyValue}},
+		{"metadata.region:[eu-1 TO eu-3]", &termExpr{Field: "metadata.region", Op: "range", Value: "eu-1", High: "eu-3"}},
+		{"a b OR c", &orExpr{
+			&andExpr{&termExpr{Field: "message", Op: "=", Value: "a"}, &termExpr{Field: "message", Op: "=", Value: "b"}},
+			&termExpr{Field: "message", Op: "=", Value: "c"},
+		}},
+		{"-a AND NOT (b OR c)", &andExpr{
+			&notExpr{&termExpr{Field: "message", Op: "=", Value: "a"}},
+			&notExpr{&orExpr{&termExpr{Field: "message", Op: "=", Value: "b"}, &termExpr{Field: "message", Op: "=", Value: "c"}}},
+		}},
+	}
+	for _, tt := range tests {
+		got, err := ParseQuery(tt.in)
+		if err != nil {
+			t.Errorf("ParseQuery(%q): %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseQuery(%q) = %#v, want %#v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	tests := []struct {
+		in  string
+		pos int
+	}{
+		{"(a", 2},
+		{"a)", 1},
+		{"colour:red", 0},
+		{"level:loud", 0},
+		{"service:>a", 0},
+		{"timestamp:2024-05-01", 0},
+		{"timestamp>yesterday", 0},
+		{`/(/`, 0},
+		{`"unterminated`, 0},
+		{"metadata.:x", 0},
+		{"metadata.n:[1 2]", 14},
+		{"é)", 2},
+	}
+	for _, tt := range tests {
+		_, err := ParseQuery(tt.in)
+		var qe *QueryError
+		if !errors.As(err, &qe) {
+			t.Errorf("ParseQuery(%q) error = %v, want a QueryError", tt.in, err)
+			continue
+		}
+		if qe.Pos != tt.pos {
+			t.Errorf("ParseQuery(%q) error at %d, want %d (%v)", tt.in, qe.Pos, tt.pos, err)
+		}
+	}
+}
+
+func TestCompileQuery(t *testing.T) {
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in      string
+		dialect Dialect
+		sql     string
+		args    []interface{}
+	}{
+		{"service:api", ClickHouse, "(service = ?)", []interface{}{"api"}},
+		{"service:api", Postgres, "(service = $3)", []interface{}{"api"}},
+		{"service:ap*", ClickHouse, "(startsWith(service, ?))", []interface{}{"ap"}},
+		{"service:a_*", Postgres, "(service LIKE $3)", []interface{}{`a\_%`}},
+		{"level:error", Postgres, "(severity = $3)", []interface{}{17}},
+		{"level!=warn", ClickHouse, "NOT (severity = ?)", []interface{}{13}},
+		{"level:[info TO error]", ClickHouse, "(severity >= ? AND severity <= ?)", []interface{}{9, 17}},
+		{"timestamp>=2024-05-01", Postgres, "(timestamp >= $3)", []interface{}{day}},
+		{"metadata.region:eu", ClickHouse, "(metadata[?] = ?)", []interface{}{"region", "eu"}},
+		{"metadata.region:eu", Postgres, "(metadata @> jsonb_build_object($3::text, $4::text))", []interface{}{"region", "eu"}},
+		{"metadata.user:*", Postgres, "(metadata ? $3)", []interface{}{"user"}},
+		{"metadata.status>=500", ClickHouse, "(toFloat64OrNull(metadata[?]) >= ?)", []interface{}{"status", 500.0}},
+		{"metadata.tier>b", ClickHouse, "(metadata[?] > ?)", []interface{}{"tier", "b"}},
+		{"/time(d)?out/", ClickHouse, "(match(message, ?))", []interface{}{"time(d)?out"}},
+		{"timeout", ClickHouse, "(hasToken(lower(message), ?))", []interface{}{"timeout"}},
+		{"/(?i)time(d)?out/", Postgres, "(message ~ $3)", []interface{}{"[Tt][Ii][Mm][Ee](?:[Dd])?[Oo][Uu][Tt]"}},
+		{"/\\bGET\\b/", Postgres, "(message ~ $3)", []interface{}{`\yGET\y`}},
+		{"api.example.com", Postgres, "(" + messageVector + " @@ phraseto_tsquery('simple', $3))", []interface{}{"api example com"}},
+		{"user_1*", Postgres, "(" + messageVector + " @@ to_tsquery('simple', $3))", []interface{}{"'user' <-> '1':*"}},
+		{"a OR -b", ClickHouse, "((hasToken(lower(message), ?)) OR NOT (hasToken(lower(message), ?)))", []interface{}{"a", "b"}},
+	}
+	for _, tt := range tests {
+		expr, err := ParseQuery(tt.in)
+		if err != nil {
+			t.Errorf("ParseQuery(%q): %v", tt.in, err)
+			continue
+		}
+		sql, args := CompileQuery(expr, tt.dialect, 3)
+		if sql != tt.sql || !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("CompileQuery(%q, %v) = %q %v, want %q %v", tt.in, tt.dialect, sql, args, tt.sql, tt.args)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package logquery
+
+import (
+	"fmt"
+	"regexp/syntax"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Queries use RE2 syntax, which the parser checks and ClickHouse's match
+// runs. Postgres runs its own ARE flavour instead, where \b is a backspace,
+// \pL is unknown and flags cannot be scoped to a group, so postgresRegex
+// rewrites the parsed expression in ARE syntax.
+
+// maxRepeat is the largest count Postgres allows in {m,n}.
+const maxRepeat = 255
+
+// noMatch matches only NUL, which Postgres text cannot hold.
+const noMatch = `[^\u0001-\U0010FFFF]`
+
+// postgresRegex rewrites the RE2 expression pattern as a Postgres ARE that
+// matches the same strings.
+func postgresRegex(pattern string) (string, error) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := writeARE(&b, re); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func writeARE(b *strings.Builder, re *syntax.Regexp) error {
+	switch re.Op {
+	case syntax.OpNoMatch:
+		b.WriteString(noMatch)
+	case syntax.OpEmptyMatch:
+		b.WriteString("(?:)")
+	case syntax.OpLiteral:
+		for _, r := range re.Rune {
+			writeARELiteral(b, r, re.Flags&syntax.FoldCase != 0)
+		}
+	case syntax.OpCharClass:
+		writeAREClass(b, re.Rune)
+	case syntax.OpAnyCharNotNL:
+		b.WriteString(`[^\n]`)
+	case syntax.OpAnyChar:
+		// Without the n flag, . also matches a newline.
+		b.WriteString(".")
+	case syntax.OpBeginLine:
+		b.WriteString(`(?:^|(?<=\n))`)
+	case syntax.OpEndLine:
+		b.WriteString(`(?:$|(?=\n))`)
+	case syntax.OpBeginText:
+		b.WriteString("^")
+	case syntax.OpEndText:
+		b.WriteString("$")
+	case syntax.OpWordBoundary:
+		b.WriteString(`\y`)
+	case syntax.OpNoWordBoundary:
+		b.WriteString(`\Y`)
+	case syntax.OpCapture:
+		b.WriteString("(?:")
+		if err := writeARE(b, re.Sub[0]); err != nil {
+			return err
+		}
+		b.WriteString(")")
+	case syntax.OpStar, syntax.OpPlus, syntax.OpQuest, syntax.OpRepeat:
+		if re.Op == syntax.OpRepeat && (re.Min > maxRepeat || re.Max > maxRepeat) {
+			return fmt.Errorf("repeat count above %d", maxRepeat)
+		}
+		if err := writeAREAtom(b, re.Sub[0]); err != nil {
+			return err
+		}
+		switch re.Op {
+		case syntax.OpStar:
+			b.WriteString("*")
+		case syntax.OpPlus:
+			b.WriteString("+")
+		case syntax.OpQuest:
+			b.WriteString("?")
+		default:
+			b.WriteString("{" + strconv.Itoa(re.Min))
+			if re.Max != re.Min {
+				b.WriteString(",")
+				if re.Max >= 0 {
+					b.WriteString(strconv.Itoa(re.Max))
+				}
+			}
+			b.WriteString("}")
+		}
+		if re.Flags&syntax.NonGreedy != 0 {
+			b.WriteString("?")
+		}
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			if sub.Op == syntax.OpAlternate {
+				if err := writeAREGroup(b, sub); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := writeARE(b, sub); err != nil {
+				return err
+			}
+		}
+	case syntax.OpAlternate:
+		for i, sub := range re.Sub {
+			if i > 0 {
+				b.WriteString("|")
+			}
+			if err := writeARE(b, sub); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported regular expression %s", re)
+	}
+	return nil
+}
+
+// writeAREAtom writes re so that a following quantifier applies to all of it.
+func writeAREAtom(b *strings.Builder, re *syntax.Regexp) error {
+	switch re.Op {
+	case syntax.OpCharClass, syntax.OpAnyChar, syntax.OpAnyCharNotNL, syntax.OpCapture:
+		return writeARE(b, re)
+	case syntax.OpLiteral:
+		if len(re.Rune) == 1 {
+			return writeARE(b, re)
+		}
+	}
+	return writeAREGroup(b, re)
+}
+
+func writeAREGroup(b *strings.Builder, re *syntax.Regexp) error {
+	b.WriteString("(?:")
+	if err := writeARE(b, re); err != nil {
+		return err
+	}
+	b.WriteString(")")
+	return nil
+}
+
+func writeARELiteral(b *strings.Builder, r rune, fold bool) {
+	if fold {
+		var folds []rune
+		for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+			folds = append(folds, f)
+		}
+		if len(folds) > 0 {
+			b.WriteString("[")
+			for _, f := range append([]rune{r}, folds...) {
+				writeAREClassRune(b, f)
+			}
+			b.WriteString("]")
+			return
+		}
+	}
+	if r < utf8.RuneSelf && strings.ContainsRune(`\^$.[]|()?*+{}`, r) {
+		b.WriteByte('\\')
+	}
+	if r < ' ' || r == 0x7f {
+		writeAREClassRune(b, r)
+		return
+	}
+	b.WriteRune(r)
+}
+
+// writeAREClass writes the class with ranges as pairs of bounds, as
+// syntax.Regexp holds it. A class reaching both ends of the character set is
+// written as the negation of its gaps. NUL never appears in Postgres text, so
+// it is left out.
+func writeAREClass(b *strings.Builder, ranges []rune) {
+	negate := len(ranges) > 0 && ranges[0] <= 1 && ranges[len(ranges)-1] == unicode.MaxRune
+	if negate {
+		var gaps []rune
+		for i := 1; i+1 < len(ranges); i += 2 {
+			gaps = append(gaps, ranges[i]+1, ranges[i+1]-1)
+		}
+		if len(gaps) == 0 {
+			// Every character, as . is without the n flag.
+			b.WriteString(".")
+			return
+		}
+		ranges = gaps
+	}
+
+	var class strings.Builder
+	for i := 0; i+1 < len(ranges); i += 2 {
+		lo, hi := max(ranges[i], 1), ranges[i+1]
+		if lo > hi {
+			continue
+		}
+		writeAREClassRune(&class, lo)
+		if hi != lo {
+			class.WriteString("-")
+			writeAREClassRune(&class, hi)
+		}
+	}
+	switch {
+	case class.Len() == 0 && negate:
+		b.WriteString(".")
+	case class.Len() == 0:
+		b.WriteString(noMatch)
+	case negate:
+		b.WriteString("[^" + class.String() + "]")
+	default:
+		b.WriteString("[" + class.String() + "]")
+	}
+}
+
+// writeAREClassRune writes r inside a bracket expression, escaping anything
+// other than a letter or digit.
+func writeAREClassRune(b *strings.Builder, r rune) {
+	switch {
+	case 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9':
+		b.WriteRune(r)
+	case r <= 0xffff:
+		fmt.Fprintf(b, `\u%04X`, r)
+	default:
+		fmt.Fprintf(b, `\U%08X`, r)
+	}
+}
@@ -0,0 +1,43 @@
+package logquery
+
+import "testing"
+
+func TestPostgresRegex(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{`time(d)? ?out`, `time(?:d)? ?out`},
+		{`a.b`, `a[^\n]b`},
+		{`(?s)a.b`, `a.b`},
+		{`\d+\.\d{1,3}`, `[0-9]+\.[0-9]{1,3}`},
+		{`\bGET\b`, `\yGET\y`},
+		{`^(GET|POST) /api`, `^(?:GET|POST) /api`},
+		{`ab+?`, `ab+?`},
+		{`(ab)+`, `(?:ab)+`},
+		{`x(?:ab){2,}`, `x(?:ab){2,}`},
+		{`[^a-z]`, `[^a-z]`},
+		{`[α-ω]+`, `[\u03B1-\u03C9]+`},
+		{`(?m)^err$`, `(?:^|(?<=\n))err(?:$|(?=\n))`},
+		{`\Aerr\z`, `^err$`},
+		{`[\]\\^-]`, `[\u002D\u005C-\u005E]`},
+		{`a|b`, `[a-b]`},
+		{`é|ü`, `[\u00E9\u00FC]`},
+		{`(?i)k`, `[Kk\u212A]`},
+	}
+	for _, tt := range tests {
+		got, err := postgresRegex(tt.in)
+		if err != nil {
+			t.Errorf("postgresRegex(%q): %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("postgresRegex(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{`a{256}`, `a{1,300}`, `(`} {
+		if got, err := postgresRegex(in); err == nil {
+			t.Errorf("postgresRegex(%q) = %q, want an error", in, got)
+		}
+	}
+}
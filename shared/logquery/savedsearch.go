@@ -0,0 +1,361 @@
+package logquery
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/davidojo1144/LogStream/shared/auth"
+	"github.com/davidojo1144/LogStream/shared/logs"
+	"github.com/davidojo1144/LogStream/shared/metrics"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	maxSavedSearchName    = 200
+	maxSavedSearchColumns = 50
+)
+
+// SavedSearch is a named search stored in "SavedSearch". A deployment is one
+// tenant, so every reader sees every saved search; only its owner and admin
+// keys may change or delete it.
+type SavedSearch struct {
+	ID        string      `json:"id"`
+	Name      string      `json:"name"`
+	Query     string      `json:"query"`
+	Range     SearchRange `json:"range"`
+	Columns   []string    `json:"columns"`
+	Owner     string      `json:"owner"`
+	CreatedAt time.Time   `json:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at"`
+}
+
+// SearchRange is the time range of a saved search: relative, the Last
+// duration before it runs (such as 15m or 7d), or absolute, from Start to
+// End. An empty range uses the defaults of the endpoint it runs on.
+type SearchRange struct {
+	Last  string     `json:"last,omitempty"`
+	Start *time.Time `json:"start,omitempty"`
+	End   *time.Time `json:"end,omitempty"`
+}
+
+func (s *SavedSearch) validate() error {
+	s.Name = strings.TrimSpace(s.Name)
+	if s.Name == "" || len(s.Name) > maxSavedSearchName {
+		return fmt.Errorf("name must be between 1 and %d characters", maxSavedSearchName)
+	}
+	if _, err := ParseQuery(s.Query); err != nil {
+		return fmt.Errorf("query: %w", err)
+	}
+
+	r := s.Range
+	if r.Last != "" {
+		if r.Start != nil || r.End != nil {
+			return errors.New("range is either last or start and end, not both")
+		}
+		if _, ok := parseRelativeRange(r.Last); !ok {
+			return errors.New("range.last must be a positive duration such as 15m, 24h or 7d")
+		}
+	}
+	if r.Start != nil && r.End != nil && !r.Start.Before(*r.End) {
+		return errors.New("range.start must be before range.end")
+	}
+
+	if len(s.Columns) > maxSavedSearchColumns {
+		return fmt.Errorf("at most %d columns", maxSavedSearchColumns)
+	}
+	for _, c := range s.Columns {
+		switch {
+		case c == "timestamp", c == "service", c == "level", c == "message":
+		case strings.HasPrefix(c, "metadata.") && len(c) > len("metadata."):
+		default:
+			return fmt.Errorf("columns must be timestamp, service, level, message or metadata.<key>, not %q", c)
+		}
+	}
+	if s.Columns == nil {
+		s.Columns = []string{}
+	}
+	return nil
+}
+
+// parseRelativeRange reads a Go duration, or a number of days such as 7d.
+func parseRelativeRange(v string) (time.Duration, bool) {
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		n, err := strconv.Atoi(days)
+		return time.Duration(n) * 24 * time.Hour, err == nil && n > 0
+	}
+	d, err := time.ParseDuration(v)
+	return d, err == nil && d > 0
+}
+
+// SavedSearches serves the /searches endpoints and runs saved searches on
+// the read endpoints.
+type SavedSearches struct {
+	db   *sql.DB
+	auth *auth.ReadAuth
+}
+
+func NewSavedSearches(db *sql.DB, auth *auth.ReadAuth) *SavedSearches {
+	return &SavedSearches{db: db, auth: auth}
+}
+
+// savedSearchColumns are the columns scanSavedSearch reads.
+const savedSearchColumns = `id, name, query, "rangeLast", "rangeStart", "rangeEnd", columns, "ownerId", "createdAt", "updatedAt"`
+
+func scanSavedSearch(row interface{ Scan(...any) error }) (*SavedSearch, error) {
+	var s SavedSearch
+	var last sql.NullString
+	var start, end sql.NullTime
+	// database/sql cannot scan arrays itself. A Map is not safe for
+	// concurrent use, so each scan has its own.
+	columns := pgtype.NewMap().SQLScanner(&s.Columns)
+	if err := row.Scan(&s.ID, &s.Name, &s.Query, &last, &start, &end, columns, &s.Owner, &s.CreatedAt, &s.UpdatedAt); err != nil {
+		return nil, err
+	}
+	s.Range.Last = last.String
+	if start.Valid {
+		s.Range.Start = &start.Time
+	}
+	if end.Valid {
+		s.Range.End = &end.Time
+	}
+	if s.Columns == nil {
+		s.Columns = []string{}
+	}
+	return &s, nil
+}
+
+// Get returns the saved search with the given ID, or nil if there is none.
+func (s *SavedSearches) Get(ctx context.Context, id string) (*SavedSearch, error) {
+	start := time.Now()
+	search, err := scanSavedSearch(s.db.QueryRowContext(ctx, `SELECT `+savedSearchColumns+` FROM "SavedSearch" WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+	metrics.ObserveQuery("saved_search", start, &err)
+	return search, err
+}
+
+// Expand returns the parameters of a read request with the saved search
+// named by its saved parameter filled in. The saved query is combined with
+// q, and the saved range applies unless start_time or end_time are given.
+// s may be nil when there is no database, in which case saved is rejected.
+func (s *SavedSearches) Expand(ctx context.Context, query url.Values) (url.Values, error) {
+	id := query.Get("saved")
+	if id == "" {
+		return query, nil
+	}
+	if s == nil {
+		return nil, errors.New("saved searches need a Postgres database")
+	}
+	search, err := s.Get(ctx, id)
+	if err != nil {
+		log.Printf("Error loading saved search %s: %v", id, err)
+		return nil, errors.New("failed to load saved search")
+	}
+	if search == nil {
+		return nil, fmt.Errorf("unknown saved search %q", id)
+	}
+
+	expanded := make(url.Values, len(query))
+	for k, v := range query {
+		expanded[k] = v
+	}
+	if search.Query != "" {
+		q := search.Query
+		if given := query.Get("q"); given != "" {
+			q = "(" + q + ") AND (" + given + ")"
+		}
+		expanded.Set("q", q)
+	}
+	if query.Get("start_time") == "" && query.Get("end_time") == "" {
+		r := search.Range
+		if last, ok := parseRelativeRange(r.Last); ok {
+			expanded.Set("start_time", time.Now().Add(-last).UTC().Format(time.RFC3339))
+		}
+		if r.Start != nil {
+			expanded.Set("start_time", r.Start.UTC().Format(time.RFC3339))
+		}
+		if r.End != nil {
+			expanded.Set("end_time", r.End.UTC().Format(time.RFC3339))
+		}
+	}
+	return expanded, nil
+}
+
+// ServeHTTP handles GET and POST on /searches, and GET, PUT and DELETE on
+// /searches/{id}.
+func (s *SavedSearches) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key, ok := s.auth.Authorize(w, r)
+	if !ok {
+		return
+	}
+
+	id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/searches"), "/")
+	if strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		s.list(w, r)
+	case id == "" && r.Method == http.MethodPost:
+		s.create(w, r, key)
+	case id != "" && r.Method == http.MethodGet:
+		s.get(w, r, id)
+	case id != "" && (r.Method == http.MethodPut || r.Method == http.MethodDelete):
+		s.change(w, r, key, id)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *SavedSearches) list(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	rows, err := s.db.QueryContext(r.Context(), `SELECT `+savedSearchColumns+` FROM "SavedSearch" ORDER BY name, id`)
+	metrics.ObserveQuery("saved_search", start, &err)
+	if err != nil {
+		log.Printf("Error querying saved searches: %v", err)
+		http.Error(w, "Failed to fetch saved searches", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	searches := []SavedSearch{}
+	for rows.Next() {
+		search, err := scanSavedSearch(rows)
+		if err != nil {
+			log.Printf("Error reading saved search: %v", err)
+			http.Error(w, "Failed to fetch saved searches", http.StatusInternalServerError)
+			return
+		}
+		searches = append(searches, *search)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error reading saved searches: %v", err)
+		http.Error(w, "Failed to fetch saved searches", http.StatusInternalServerError)
+		return
+	}
+	writeSavedSearch(w, http.StatusOK, searches)
+}
+
+func (s *SavedSearches) get(w http.ResponseWriter, r *http.Request, id string) {
+	search, err := s.Get(r.Context(), id)
+	if err != nil {
+		log.Printf("Error loading saved search %s: %v", id, err)
+		http.Error(w, "Failed to fetch saved search", http.StatusInternalServerError)
+		return
+	}
+	if search == nil {
+		http.Error(w, "saved search not found", http.StatusNotFound)
+		return
+	}
+	writeSavedSearch(w, http.StatusOK, search)
+}
+
+func (s *SavedSearches) create(w http.ResponseWriter, r *http.Request, key *auth.ApiKey) {
+	search, ok := readSavedSearch(w, r)
+	if !ok {
+		return
+	}
+	search.ID = logs.NewID(time.Now())
+	search.Owner = key.UserID
+
+	start := time.Now()
+	row := s.db.QueryRowContext(r.Context(), `INSERT INTO "SavedSearch" (id, name, query, "rangeLast", "rangeStart", "rangeEnd", columns, "ownerId", "createdAt", "updatedAt")
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7, $8, now(), now())
+		RETURNING `+savedSearchColumns,
+		search.ID, search.Name, search.Query, search.Range.Last, search.Range.Start, search.Range.End, search.Columns, search.Owner)
+	search, err := scanSavedSearch(row)
+	metrics.ObserveQuery("saved_search", start, &err)
+	if err != nil {
+		log.Printf("Error creating saved search: %v", err)
+		http.Error(w, "Failed to save search", http.StatusInternalServerError)
+		return
+	}
+	writeSavedSearch(w, http.StatusCreated, search)
+}
+
+// change updates or deletes a saved search on behalf of its owner or an
+// admin key.
+func (s *SavedSearches) change(w http.ResponseWriter, r *http.Request, key *auth.ApiKey, id string) {
+	existing, err := s.Get(r.Context(), id)
+	if err != nil {
+		log.Printf("Error loading saved search %s: %v", id, err)
+		http.Error(w, "Failed to fetch saved search", http.StatusInternalServerError)
+		return
+	}
+	if existing == nil {
+		http.Error(w, "saved search not found", http.StatusNotFound)
+		return
+	}
+	if existing.Owner != key.UserID && (key.ID == "" || !key.HasScope(auth.ScopeAdmin)) {
+		http.Error(w, "Only the owner or an admin API key may change a saved search", http.StatusForbidden)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		start := time.Now()
+		_, err := s.db.ExecContext(r.Context(), `DELETE FROM "SavedSearch" WHERE id = $1`, id)
+		metrics.ObserveQuery("saved_search", start, &err)
+		if err != nil {
+			log.Printf("Error deleting saved search %s: %v", id, err)
+			http.Error(w, "Failed to delete saved search", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	search, ok := readSavedSearch(w, r)
+	if !ok {
+		return
+	}
+	start := time.Now()
+	row := s.db.QueryRowContext(r.Context(), `UPDATE "SavedSearch" SET name = $2, query = $3, "rangeLast" = NULLIF($4, ''), "rangeStart" = $5, "rangeEnd" = $6, columns = $7, "updatedAt" = now()
+		WHERE id = $1
+		RETURNING `+savedSearchColumns,
+		id, search.Name, search.Query, search.Range.Last, search.Range.Start, search.Range.End, search.Columns)
+	search, err = scanSavedSearch(row)
+	if err == sql.ErrNoRows {
+		// Deleted since it was loaded
+		http.Error(w, "saved search not found", http.StatusNotFound)
+		return
+	}
+	metrics.ObserveQuery("saved_search", start, &err)
+	if err != nil {
+		log.Printf("Error updating saved search %s: %v", id, err)
+		http.Error(w, "Failed to save search", http.StatusInternalServerError)
+		return
+	}
+	writeSavedSearch(w, http.StatusOK, search)
+}
+
+// readSavedSearch decodes and validates the name, query, range and columns
+// of a saved search from the request body.
+func readSavedSearch(w http.ResponseWriter, r *http.Request) (*SavedSearch, bool) {
+	var search SavedSearch
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&search); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return nil, false
+	}
+	if err := search.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return &search, true
+}
+
+func writeSavedSearch(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
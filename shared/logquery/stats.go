@@ -0,0 +1,340 @@
+package logquery
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/davidojo1144/LogStream/shared/logs"
+)
+
+// statsIntervals are the bucket sizes /stats accepts. Each divides a day or
+// is a whole number of days, so ClickHouse's toStartOfInterval, the Postgres
+// expression in bucketSQL and bucketStart all put bucket edges in the same
+// place.
+var statsIntervals = []time.Duration{
+	time.Minute, 5 * time.Minute, 10 * time.Minute, 15 * time.Minute, 30 * time.Minute,
+	time.Hour, 2 * time.Hour, 3 * time.Hour, 6 * time.Hour, 12 * time.Hour,
+	24 * time.Hour, 7 * 24 * time.Hour,
+}
+
+const (
+	// maxAutoBuckets is the most buckets an automatically chosen interval
+	// produces.
+	maxAutoBuckets = 200
+	// maxStatsBuckets caps the buckets of an explicit interval.
+	maxStatsBuckets = 10000
+	// maxStatsGroups is how many groups are reported separately; the rest
+	// are added up under statsOtherGroup.
+	maxStatsGroups  = 20
+	statsOtherGroup = "(other)"
+)
+
+// LogStats is the number of matching logs in one bucket, split by group
+// when /stats was asked to group.
+type LogStats struct {
+	Timestamp time.Time         `json:"timestamp"`
+	Count     uint64            `json:"count"`
+	Groups    map[string]uint64 `json:"groups,omitempty"`
+}
+
+// StatsResult is the /stats response with envelope=true. Without it, only
+// Buckets is sent, as a bare array.
+type StatsResult struct {
+	Interval string     `json:"interval"`
+	Timezone string     `json:"timezone"`
+	GroupBy  string     `json:"group_by,omitempty"`
+	Buckets  []LogStats `json:"buckets"`
+}
+
+// StatsParams are the bucketing options of /stats.
+type StatsParams struct {
+	Start, End time.Time
+	Interval   time.Duration
+	Location   *time.Location
+	GroupBy    string // "", level, service or metadata.<key>
+	Envelope   bool
+}
+
+// ParseStatsParams reads interval (one of statsIntervals, such as 5m, 1h or
+// 1d; picked from the range when absent), tz (an IANA zone, default UTC),
+// group_by and envelope for the range start to end.
+func ParseStatsParams(query url.Values, start, end time.Time) (StatsParams, error) {
+	p := StatsParams{Start: start, End: end, Location: time.UTC, GroupBy: query.Get("group_by")}
+	if !end.After(start) {
+		return p, fmt.Errorf("end_time must be after start_time")
+	}
+
+	if v := query.Get("envelope"); v != "" {
+		envelope, err := strconv.ParseBool(v)
+		if err != nil {
+			return p, fmt.Errorf("envelope must be true or false")
+		}
+		p.Envelope = envelope
+	}
+
+	if tz := query.Get("tz"); tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return p, fmt.Errorf("unknown time zone %q", tz)
+		}
+		p.Location = loc
+	}
+
+	switch g := p.GroupBy; {
+	case g == "", g == "level", g == "service":
+	case strings.HasPrefix(g, "metadata.") && len(g) > len("metadata."):
+	default:
+		return p, fmt.Errorf("group_by must be level, service or metadata.<key>")
+	}
+
+	span := end.Sub(start)
+	if v := query.Get("interval"); v != "" {
+		iv, ok := parseStatsInterval(v)
+		if !ok {
+			return p, fmt.Errorf("interval must be one of %s", formatStatsIntervals())
+		}
+		if span/iv > maxStatsBuckets {
+			return p, fmt.Errorf("interval %s gives more than %d buckets for this range", v, maxStatsBuckets)
+		}
+		p.Interval = iv
+		return p, nil
+	}
+
+	p.Interval = statsIntervals[len(statsIntervals)-1]
+	for _, iv := range statsIntervals {
+		if span/iv <= maxAutoBuckets {
+			p.Interval = iv
+			break
+		}
+	}
+	return p, nil
+}
+
+func parseStatsInterval(v string) (time.Duration, bool) {
+	var iv time.Duration
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, false
+		}
+		iv = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if iv, err = time.ParseDuration(v); err != nil {
+			return 0, false
+		}
+	}
+	for _, allowed := range statsIntervals {
+		if iv == allowed {
+			return iv, true
+		}
+	}
+	return 0, false
+}
+
+func formatInterval(iv time.Duration) string {
+	switch {
+	case iv%(24*time.Hour) == 0:
+		return strconv.Itoa(int(iv/(24*time.Hour))) + "d"
+	case iv%time.Hour == 0:
+		return strconv.Itoa(int(iv/time.Hour)) + "h"
+	}
+	return strconv.Itoa(int(iv/time.Minute)) + "m"
+}
+
+func formatStatsIntervals() string {
+	names := make([]string, len(statsIntervals))
+	for i, iv := range statsIntervals {
+		names[i] = formatInterval(iv)
+	}
+	return strings.Join(names, ", ")
+}
+
+// Columns returns the bucket and group columns of the stats query, selected
+// as bucket and grp, with their arguments. Postgres placeholders are numbered
+// from firstArg.
+func (p StatsParams) Columns(dialect Dialect, firstArg int) (string, []interface{}) {
+	c := &sqlCompiler{dialect: dialect, next: firstArg}
+	cols := p.bucketSQL(c) + " AS bucket, " + p.groupSQL(c) + " AS grp"
+	return cols, c.args
+}
+
+func (p StatsParams) bucketSQL(c *sqlCompiler) string {
+	tz := p.Location.String()
+	if c.dialect == ClickHouse {
+		n, unit := int(p.Interval/time.Minute), "MINUTE"
+		switch {
+		case p.Interval%(24*time.Hour) == 0:
+			n, unit = int(p.Interval/(24*time.Hour)), "DAY"
+		case p.Interval%time.Hour == 0:
+			n, unit = int(p.Interval/time.Hour), "HOUR"
+		}
+		return fmt.Sprintf("toStartOfInterval(timestamp, INTERVAL %d %s, %s)", n, unit, c.arg(tz))
+	}
+
+	// Floor the local wall-clock time to the interval, counted from the
+	// epoch, then turn it back into an instant. Lite stores UTC.
+	local := "(timestamp AT TIME ZONE 'UTC' AT TIME ZONE " + c.arg(tz) + ")"
+	seconds := c.arg(int64(p.Interval / time.Second))
+	floored := "to_timestamp(floor(extract(epoch FROM " + local + ")::float8 / " + seconds + "::float8) * " + seconds + "::float8)"
+	return "(" + floored + " AT TIME ZONE 'UTC' AT TIME ZONE " + c.arg(tz) + ")"
+}
+
+func (p StatsParams) groupSQL(c *sqlCompiler) string {
+	switch {
+	case p.GroupBy == "level" && c.dialect == ClickHouse:
+		return "toString(severity)"
+	case p.GroupBy == "level":
+		return "severity::text"
+	case p.GroupBy == "service":
+		return "service"
+	case p.GroupBy != "" && c.dialect == ClickHouse:
+		return "metadata[" + c.arg(strings.TrimPrefix(p.GroupBy, "metadata.")) + "]"
+	case p.GroupBy != "":
+		return "COALESCE(metadata->>" + c.arg(strings.TrimPrefix(p.GroupBy, "metadata.")) + ", '')"
+	}
+	return "''"
+}
+
+// bucketStart is the start of the bucket t falls in, matching bucketSQL:
+// intervals under a day count from local midnight, whole days from the
+// epoch.
+func (p StatsParams) bucketStart(t time.Time) time.Time {
+	t = t.In(p.Location)
+	day := 24 * time.Hour
+	if p.Interval%day == 0 {
+		days := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Unix() / 86400
+		n := int64(p.Interval / day)
+		return time.Date(1970, 1, 1+int(days-days%n), 0, 0, 0, 0, p.Location)
+	}
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, p.Location)
+	return midnight.Add(t.Sub(midnight) / p.Interval * p.Interval)
+}
+
+// Response is what /stats sends for res: the whole result with Envelope,
+// otherwise just its buckets.
+func (p StatsParams) Response(res StatsResult) interface{} {
+	if p.Envelope {
+		return res
+	}
+	return res.Buckets
+}
+
+// StatsRow is one bucket and group as counted by the database.
+type StatsRow struct {
+	Bucket time.Time
+	Group  string
+	Count  uint64
+}
+
+// BuildStats fills every bucket from Start to End, with zero for buckets
+// without logs, and keeps the largest groups.
+func (p StatsParams) BuildStats(rows []StatsRow) StatsResult {
+	res := StatsResult{Interval: formatInterval(p.Interval), Timezone: p.Location.String(), GroupBy: p.GroupBy, Buckets: []LogStats{}}
+
+	groups := p.topGroups(rows)
+	index := make(map[int64]int)
+	for b := p.bucketStart(p.Start); !b.After(p.End); {
+		index[b.Unix()] = len(res.Buckets)
+		res.Buckets = append(res.Buckets, p.emptyBucket(b, groups))
+
+		next := b.Add(p.Interval)
+		if p.Interval%(24*time.Hour) == 0 {
+			next = b.AddDate(0, 0, int(p.Interval/(24*time.Hour)))
+		}
+		if next = p.bucketStart(next); !next.After(b) {
+			next = b.Add(p.Interval)
+		}
+		b = next
+	}
+
+	sorted := false
+	for _, r := range rows {
+		i, ok := index[r.Bucket.Unix()]
+		if !ok {
+			// A bucket edge moved by a daylight saving change.
+			i = len(res.Buckets)
+			index[r.Bucket.Unix()] = i
+			res.Buckets = append(res.Buckets, p.emptyBucket(r.Bucket.In(p.Location), groups))
+			sorted = true
+		}
+		bucket := &res.Buckets[i]
+		bucket.Count += r.Count
+		if bucket.Groups != nil {
+			name := p.groupName(r.Group)
+			if _, ok := groups[name]; !ok {
+				name = statsOtherGroup
+			}
+			bucket.Groups[name] += r.Count
+		}
+	}
+	if sorted {
+		sort.Slice(res.Buckets, func(i, j int) bool { return res.Buckets[i].Timestamp.Before(res.Buckets[j].Timestamp) })
+	}
+	return res
+}
+
+func (p StatsParams) emptyBucket(t time.Time, groups map[string]bool) LogStats {
+	s := LogStats{Timestamp: t}
+	if p.GroupBy != "" {
+		s.Groups = make(map[string]uint64, len(groups))
+		for g := range groups {
+			s.Groups[g] = 0
+		}
+	}
+	return s
+}
+
+// topGroups picks the maxStatsGroups groups with the most logs, plus
+// statsOtherGroup when there are more.
+func (p StatsParams) topGroups(rows []StatsRow) map[string]bool {
+	if p.GroupBy == "" {
+		return nil
+	}
+	totals := make(map[string]uint64)
+	for _, r := range rows {
+		totals[p.groupName(r.Group)] += r.Count
+	}
+	names := make([]string, 0, len(totals))
+	for name := range totals {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if totals[names[i]] != totals[names[j]] {
+			return totals[names[i]] > totals[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	groups := make(map[string]bool)
+	for i, name := range names {
+		if i == maxStatsGroups {
+			groups[statsOtherGroup] = true
+			break
+		}
+		groups[name] = true
+	}
+	return groups
+}
+
+// groupName turns a level group, counted by severity, into its level name.
+func (p StatsParams) groupName(g string) string {
+	if p.GroupBy == "level" {
+		return severityName(g)
+	}
+	return g
+}
+
+// severityName is the level name of a severity selected as text.
+func severityName(s string) string {
+	if n, err := strconv.Atoi(s); err == nil {
+		if name, ok := logs.LevelNames[n]; ok {
+			return name
+		}
+	}
+	return s
+}
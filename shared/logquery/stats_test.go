@@ -0,0 +1,115 @@
+package logquery
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata" // time zones without a system database
+)
+
+func TestBucketStart(t *testing.T) {
+	kolkata, err := time.LoadLocation("Asia/Kolkata") // UTC+5:30
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2024, 5, 1, 10, 47, 12, 0, time.UTC) // a Wednesday
+	tests := []struct {
+		interval time.Duration
+		loc      *time.Location
+		want     time.Time
+	}{
+		{time.Minute, time.UTC, time.Date(2024, 5, 1, 10, 47, 0, 0, time.UTC)},
+		{15 * time.Minute, time.UTC, time.Date(2024, 5, 1, 10, 45, 0, 0, time.UTC)},
+		{3 * time.Hour, time.UTC, time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)},
+		{24 * time.Hour, time.UTC, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)},
+		// Weeks count from the epoch, a Thursday.
+		{7 * 24 * time.Hour, time.UTC, time.Date(2024, 4, 25, 0, 0, 0, 0, time.UTC)},
+		// 16:17 local: hours start on the local hour, days at local midnight.
+		{time.Hour, kolkata, time.Date(2024, 5, 1, 16, 0, 0, 0, kolkata)},
+		{24 * time.Hour, kolkata, time.Date(2024, 5, 1, 0, 0, 0, 0, kolkata)},
+	}
+	for _, tt := range tests {
+		p := StatsParams{Interval: tt.interval, Location: tt.loc}
+		if got := p.bucketStart(at); !got.Equal(tt.want) {
+			t.Errorf("bucketStart(%v) with %v in %v = %v, want %v", at, tt.interval, tt.loc, got, tt.want)
+		}
+	}
+}
+
+func TestParseStatsParams(t *testing.T) {
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		query    string
+		span     time.Duration
+		interval time.Duration
+		wantErr  bool
+	}{
+		{"", time.Hour, time.Minute, false},
+		{"", 24 * time.Hour, 10 * time.Minute, false},
+		{"", 365 * 24 * time.Hour, 7 * 24 * time.Hour, false},
+		{"interval=1d", 30 * 24 * time.Hour, 24 * time.Hour, false},
+		{"interval=7m", time.Hour, 0, true},
+		{"interval=1m", 30 * 24 * time.Hour, 0, true}, // too many buckets
+		{"tz=Mars/Olympus", time.Hour, 0, true},
+		{"group_by=host", time.Hour, 0, true},
+		{"group_by=metadata.host", time.Hour, time.Minute, false},
+		{"envelope=maybe", time.Hour, 0, true},
+	}
+	for _, tt := range tests {
+		q, _ := url.ParseQuery(tt.query)
+		p, err := ParseStatsParams(q, start, start.Add(tt.span))
+		if (err != nil) != tt.wantErr || (err == nil && p.Interval != tt.interval) {
+			t.Errorf("ParseStatsParams(%q, %v) = %v, %v, want %v", tt.query, tt.span, p.Interval, err, tt.interval)
+		}
+	}
+}
+
+func TestBuildStats(t *testing.T) {
+	start := time.Date(2024, 5, 1, 10, 2, 0, 0, time.UTC)
+	p := StatsParams{Start: start, End: start.Add(14 * time.Minute), Interval: 5 * time.Minute, Location: time.UTC, GroupBy: "level"}
+	at := func(min int) time.Time { return time.Date(2024, 5, 1, 10, min, 0, 0, time.UTC) }
+
+	res := p.BuildStats([]StatsRow{
+		{at(5), "17", 3},
+		{at(5), "9", 1},
+		{at(15), "17", 2},
+	})
+	if res.Interval != "5m" || res.Timezone != "UTC" {
+		t.Errorf("BuildStats = interval %q, timezone %q", res.Interval, res.Timezone)
+	}
+	want := []struct {
+		at           time.Time
+		count, error uint64
+	}{{at(0), 0, 0}, {at(5), 4, 3}, {at(10), 0, 0}, {at(15), 2, 2}}
+	if len(res.Buckets) != len(want) {
+		t.Fatalf("BuildStats gave %d buckets, want %d: %+v", len(res.Buckets), len(want), res.Buckets)
+	}
+	for i, w := range want {
+		b := res.Buckets[i]
+		if !b.Timestamp.Equal(w.at) || b.Count != w.count || b.Groups["error"] != w.error {
+			t.Errorf("bucket %d = %+v, want %v with %d logs, %d errors", i, b, w.at, w.count, w.error)
+		}
+		if _, ok := b.Groups["info"]; !ok {
+			t.Errorf("bucket %d has no info group: %+v", i, b.Groups)
+		}
+	}
+}
+
+func TestStatsResponseKeepsArrayByDefault(t *testing.T) {
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	res := StatsResult{Interval: "1h", Timezone: "UTC", Buckets: []LogStats{{Timestamp: start, Count: 2}}}
+
+	for query, prefix := range map[string]string{"": "[", "envelope=false": "[", "envelope=true": `{"interval":"1h"`} {
+		q, _ := url.ParseQuery(query)
+		p, err := ParseStatsParams(q, start, start.Add(time.Hour))
+		if err != nil {
+			t.Fatalf("ParseStatsParams(%q): %v", query, err)
+		}
+		body, _ := json.Marshal(p.Response(res))
+		if !strings.HasPrefix(string(body), prefix) {
+			t.Errorf("/stats?%s sends %s, want it to start with %s", query, body, prefix)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+// Package logs holds the log entry every LogStream binary passes around,
+// with the level and ID rules applied to it at ingest.
+package logs
+
+import (
+	"time"
+)
+
+type Entry struct {
+	ID         string            `json:"id,omitempty"`
+	Timestamp  time.Time         `json:"timestamp"`
+	Service    string            `json:"service"`
+	Level      string            `json:"level"`
+	RawLevel   string            `json:"raw_level,omitempty"`
+	Severity   int               `json:"severity"`
+	Message    string            `json:"message"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+	Highlights [][2]int          `json:"highlights,omitempty"` // UTF-16 offsets of search matches in Message
+}
@@ -0,0 +1,95 @@
+package logs
+
+import (
+	"crypto/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Log IDs are ULIDs: 26 characters of Crockford base32 holding a 48-bit
+// millisecond timestamp followed by 80 random bits. They are assigned at
+// ingest from the entry's timestamp, so sorting by ID sorts by time, and the
+// timestamp can be read back to find the entry without a scan.
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+var ids struct {
+	sync.Mutex
+	ms      uint64
+	entropy [10]byte
+}
+
+// NewID returns a new ID for an entry logged at t. IDs made in the same
+// millisecond increase, so they keep the order they were made in.
+func NewID(t time.Time) string {
+	ms := uint64(0)
+	if t.UnixMilli() > 0 {
+		ms = uint64(t.UnixMilli()) & (1<<48 - 1)
+	}
+
+	ids.Lock()
+	// In the same millisecond, take the value after the last ID.
+	if ms != ids.ms || !increment(&ids.entropy) {
+		ids.ms = ms
+		rand.Read(ids.entropy[:])
+	}
+	var b [16]byte
+	for i := 0; i < 6; i++ {
+		b[i] = byte(ms >> (40 - 8*i))
+	}
+	copy(b[6:], ids.entropy[:])
+	ids.Unlock()
+
+	return encodeID(b)
+}
+
+// increment adds one to the big-endian entropy, reporting false when it
+// wraps around.
+func increment(entropy *[10]byte) bool {
+	for i := len(entropy) - 1; i >= 0; i-- {
+		entropy[i]++
+		if entropy[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeID writes the 128 bits as 26 base32 digits, the first holding
+// only the top 3 bits.
+func encodeID(b [16]byte) string {
+	var out [26]byte
+	var acc uint64
+	bits := 2 // pad the front so 130 bits split evenly into digits
+	n := 0
+	for _, x := range b {
+		acc = acc<<8 | uint64(x)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			out[n] = crockford[acc>>bits&31]
+			n++
+		}
+	}
+	return string(out[:])
+}
+
+// IDTime returns the timestamp in an ID made by NewID, to the
+// millisecond, and whether s is such an ID.
+func IDTime(s string) (time.Time, bool) {
+	if len(s) != 26 || s[0] > '7' {
+		return time.Time{}, false
+	}
+	var ms uint64
+	for i := 0; i < len(s); i++ {
+		d := strings.IndexByte(crockford, s[i])
+		if d < 0 {
+			return time.Time{}, false
+		}
+		if i < 10 {
+			ms = ms<<5 | uint64(d)
+		}
+	}
+	return time.UnixMilli(int64(ms)).UTC(), true
+}
@@ -0,0 +1,58 @@
+package logs
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewID(t *testing.T) {
+	// The timestamp example from the ULID specification.
+	at := time.UnixMilli(1469918176385)
+	id := NewID(at)
+	if len(id) != 26 || !strings.HasPrefix(id, "01ARYZ6S41") {
+		t.Errorf("NewID(%v) = %q, want 26 characters starting 01ARYZ6S41", at, id)
+	}
+	if got, ok := IDTime(id); !ok || !got.Equal(at) {
+		t.Errorf("IDTime(%q) = %v, %v, want %v", id, got, ok, at)
+	}
+
+	// Within a millisecond IDs keep the order they were made in, and a
+	// later millisecond sorts after them.
+	prev := id
+	for i := 0; i < 1000; i++ {
+		next := NewID(at)
+		if next <= prev {
+			t.Fatalf("NewID after %q = %q, want a larger ID", prev, next)
+		}
+		prev = next
+	}
+	if later := NewID(at.Add(time.Millisecond)); later <= prev {
+		t.Errorf("NewID a millisecond later = %q, want after %q", later, prev)
+	}
+
+	if got, _ := IDTime(NewID(time.Time{})); got.UnixMilli() != 0 {
+		t.Errorf("ID for the zero time holds %v, want the epoch", got)
+	}
+}
+
+func TestIncrementWraps(t *testing.T) {
+	e := [10]byte{8: 0xff, 9: 0xff}
+	if !increment(&e) || e != [10]byte{7: 1} {
+		t.Errorf("increment carried to %v", e)
+	}
+	for i := range e {
+		e[i] = 0xff
+	}
+	if increment(&e) {
+		t.Error("increment of the largest value reported no wrap")
+	}
+}
+
+func TestIDTimeInvalid(t *testing.T) {
+	for _, s := range []string{"", "01ARYZ6S41", "81ARYZ6S41TSV4RRFFQ69G5FAV", "01ARYZ6S41TSV4RRFFQ69G5FAU", "12345678901234567890"} {
+		if got, ok := IDTime(s); ok {
+			t.Errorf("IDTime(%q) = %v, want not an ID", s, got)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package logs
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Severities use the base values of OpenTelemetry's SeverityNumber, so a
+// higher number is more severe and levels sort by it.
+const (
+	SeverityTrace = 1
+	SeverityDebug = 5
+	SeverityInfo  = 9
+	SeverityWarn  = 13
+	SeverityError = 17
+	SeverityFatal = 21
+)
+
+// LevelNames are the canonical levels stored at ingest.
+var LevelNames = map[int]string{
+	SeverityTrace: "trace",
+	SeverityDebug: "debug",
+	SeverityInfo:  "info",
+	SeverityWarn:  "warn",
+	SeverityError: "error",
+	SeverityFatal: "fatal",
+}
+
+// levelAliases maps the level names clients send, lower-cased, to their
+// severity. The severity DEFAULT expression in init.sql and the backfill in
+// migration.sql list the same names.
+var levelAliases = map[string]int{
+	"trace": SeverityTrace, "verbose": SeverityTrace,
+	"debug": SeverityDebug, "dbg": SeverityDebug,
+	"info": SeverityInfo, "information": SeverityInfo, "notice": SeverityInfo,
+	"warn": SeverityWarn, "warning": SeverityWarn,
+	"error": SeverityError, "err": SeverityError,
+	"fatal": SeverityFatal, "critical": SeverityFatal, "crit": SeverityFatal,
+	"panic": SeverityFatal, "alert": SeverityFatal, "emerg": SeverityFatal, "emergency": SeverityFatal,
+}
+
+// NormalizeLevel returns the canonical level and severity for a level as a
+// client sent it. Empty and unknown levels count as info.
+func NormalizeLevel(level string) (string, int) {
+	severity, ok := levelAliases[strings.ToLower(strings.TrimSpace(level))]
+	if !ok {
+		severity = SeverityInfo
+	}
+	return LevelNames[severity], severity
+}
+
+// ParseSeverity reads a level name or a severity number from 1 to 24, as
+// accepted by the level and min_level query parameters.
+func ParseSeverity(s string) (int, bool) {
+	if n, err := strconv.Atoi(s); err == nil {
+		return n, n >= 1 && n <= 24
+	}
+	severity, ok := levelAliases[strings.ToLower(strings.TrimSpace(s))]
+	return severity, ok
+}
+
+// Normalize replaces the entry's level with its canonical name and severity,
+// keeping what the client sent in RawLevel. It is safe to call again on an
+// entry that was already normalized.
+func (e *Entry) Normalize() {
+	if e.RawLevel == "" {
+		e.RawLevel = e.Level
+	}
+	e.Level, e.Severity = NormalizeLevel(e.RawLevel)
+}
@@ -0,0 +1,156 @@
+// Package metrics holds the Prometheus metrics the LogStream servers share.
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "logstream_http_requests_total",
+		Help: "HTTP requests by endpoint and status code.",
+	}, []string{"endpoint", "code"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "logstream_http_request_duration_seconds",
+		Help:    "HTTP request latency by endpoint.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"endpoint"})
+
+	ingestEntries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "logstream_ingest_entries_total",
+		Help: "Log entries accepted for ingestion by service.",
+	}, []string{"service"})
+
+	ingestBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "logstream_ingest_bytes_total",
+		Help: "Request body bytes accepted for ingestion by service.",
+	}, []string{"service"})
+
+	// RateLimited counts ingest requests the limiter refused.
+	RateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "logstream_rate_limited_total",
+		Help: "Ingest requests refused by per-key limits, by limit (rate, size, quota).",
+	}, []string{"limit"})
+
+	queryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "logstream_query_duration_seconds",
+		Help:    "Latency of storage queries by query and outcome.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"query", "status"})
+
+	// WebsocketClients counts the live /ws connections.
+	WebsocketClients = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "logstream_websocket_clients",
+		Help: "Currently connected WebSocket clients.",
+	})
+
+	// ExportRows counts the rows /export streams.
+	ExportRows = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "logstream_export_rows_total",
+		Help: "Rows streamed by /export, by format.",
+	}, []string{"format"})
+
+	// AuthFailures counts rejected requests by why they were rejected.
+	AuthFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "logstream_auth_failures_total",
+		Help: "Rejected authentication attempts by reason.",
+	}, []string{"reason"})
+
+	// AuthCacheLookups counts API key cache lookups.
+	AuthCacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "logstream_auth_cache_lookups_total",
+		Help: "API key cache lookups by result (hit, miss, stale).",
+	}, []string{"result"})
+)
+
+// MaxIngestServices is how many services get their own ingest series.
+// Clients choose service names freely, so later ones are counted as
+// OtherService to keep the number of series bounded.
+const MaxIngestServices = 100
+
+// OtherService labels ingest from services past MaxIngestServices.
+const OtherService = "other"
+
+var (
+	ingestServicesMu sync.Mutex
+	ingestServices   = map[string]bool{}
+)
+
+// CountIngest records an accepted log entry of service and the request bytes
+// it came in.
+func CountIngest(service string, bytes int64) {
+	label := ingestLabel(service)
+	ingestEntries.WithLabelValues(label).Inc()
+	ingestBytes.WithLabelValues(label).Add(float64(bytes))
+}
+
+func ingestLabel(service string) string {
+	ingestServicesMu.Lock()
+	defer ingestServicesMu.Unlock()
+	if !ingestServices[service] {
+		if len(ingestServices) >= MaxIngestServices {
+			return OtherService
+		}
+		ingestServices[service] = true
+	}
+	return service
+}
+
+// Instrument records request counts and latency for an endpoint.
+func Instrument(endpoint string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+		httpRequests.WithLabelValues(endpoint, strconv.Itoa(rec.status)).Inc()
+		httpDuration.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+	}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Hijack lets WebSocket upgrades through the recorder.
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response does not implement http.Hijacker")
+	}
+	r.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+// Flush lets streaming responses such as /export through the recorder.
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// ObserveQuery records how long a storage query took. Use it as
+//
+//	defer metrics.ObserveQuery("logs", time.Now(), &err)
+func ObserveQuery(query string, start time.Time, err *error) {
+	status := "ok"
+	if *err != nil {
+		status = "error"
+	}
+	queryDuration.WithLabelValues(query, status).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,25 @@
+package metrics
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestIngestLabelFoldsServicesPastTheCap(t *testing.T) {
+	ingestServices = map[string]bool{}
+	t.Cleanup(func() { ingestServices = map[string]bool{} })
+
+	for i := 0; i < MaxIngestServices; i++ {
+		service := "svc-" + strconv.Itoa(i)
+		if got := ingestLabel(service); got != service {
+			t.Fatalf("ingestLabel(%q) = %q under the cap", service, got)
+		}
+	}
+	if got := ingestLabel("one-too-many"); got != OtherService {
+		t.Errorf("ingestLabel past the cap = %q, want %q", got, OtherService)
+	}
+	// Services seen before the cap was reached keep their own series.
+	if got := ingestLabel("svc-0"); got != "svc-0" {
+		t.Errorf("ingestLabel(svc-0) = %q after the cap, want svc-0", got)
+	}
+}
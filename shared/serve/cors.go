@@ -0,0 +1,54 @@
+// Package serve holds the HTTP plumbing shared by the LogStream servers:
+// TLS with certificate reloading and CORS.
+package serve
+
+import "net/http"
+
+// CORS lets the listed browser origins call the read endpoints. "*" allows
+// any origin. Requests without an Origin header, from servers and CLIs, are
+// not affected.
+type CORS struct {
+	origins map[string]bool
+	any     bool
+}
+
+func NewCORS(origins []string) *CORS {
+	c := &CORS{origins: make(map[string]bool, len(origins))}
+	for _, o := range origins {
+		if o == "*" {
+			c.any = true
+		}
+		c.origins[o] = true
+	}
+	return c
+}
+
+func (c *CORS) allowed(origin string) bool {
+	return c.any || c.origins[origin]
+}
+
+// CheckOrigin is a websocket.Upgrader CheckOrigin that applies the allowlist.
+func (c *CORS) CheckOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	return origin == "" || c.allowed(origin)
+}
+
+// Wrap adds CORS headers for allowed origins and answers preflight requests.
+func (c *CORS) Wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		w.Header().Add("Vary", "Origin")
+		if origin != "" && c.allowed(origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+			w.Header().Set("Access-Control-Max-Age", "600")
+		}
+
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next(w, r)
+	}
+}
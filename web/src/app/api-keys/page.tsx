@@ -2,9 +2,9 @@
 
 import { useState } from "react"
 import { useSession } from "next-auth/react"
-import { Copy, Key, Loader2, Plus, Trash2, ArrowLeft } from "lucide-react"
+import { Copy, Key, Loader2, Plus, RotateCw, Trash2, ArrowLeft } from "lucide-react"
 import useSWR from "swr"
-import { format } from "date-fns"
+import { format, formatDistanceToNow } from "date-fns"
 import Link from "next/link"
 
 import { Button } from "@/components/ui/button"
@@ -24,34 +24,69 @@ import { Card, CardContent, CardDescription, CardHeader, CardTitle } from "@/com
 interface ApiKey {
   id: string
   name: string
-  key: string
+  prefix: string | null
+  scopes: string[]
+  services: string[]
+  levels: string[]
+  expiresAt: string | null
+  successorId: string | null
+  lastUsedAt: string | null
+  lastUsedIp: string | null
+  requestCount: number
+  dailyQuota: number | null
   createdAt: string
   active: boolean
 }
 
+const SCOPES = [
+  { value: "ingest", label: "Ingest", description: "Send logs to the Collector" },
+  { value: "read", label: "Read", description: "Query logs and stats" },
+  { value: "admin", label: "Admin", description: "Everything, including administration" },
+]
+
 const fetcher = (url: string) => fetch(url).then((res) => res.json())
 
 export default function ApiKeysPage() {
   const { data: session } = useSession()
   const { data: keys, mutate, isLoading } = useSWR<ApiKey[]>("/api/keys", fetcher)
   const [newKeyName, setNewKeyName] = useState("")
+  const [newKeyScopes, setNewKeyScopes] = useState<string[]>(["ingest"])
+  const [newKeyServices, setNewKeyServices] = useState("")
+  const [newKeyLevels, setNewKeyLevels] = useState("")
+  const [newKeyExpiry, setNewKeyExpiry] = useState("")
+  const [newKeyQuota, setNewKeyQuota] = useState("")
   const [creating, setCreating] = useState(false)
   const [open, setOpen] = useState(false)
+  // The full key is returned once on creation and cannot be fetched again.
+  const [createdKey, setCreatedKey] = useState<string | null>(null)
   const { toast } = useToast()
 
   const createKey = async () => {
-    if (!newKeyName) return
+    if (!newKeyName || newKeyScopes.length === 0) return
     setCreating(true)
     try {
       const res = await fetch("/api/keys", {
         method: "POST",
         headers: { "Content-Type": "application/json" },
-        body: JSON.stringify({ name: newKeyName }),
+        body: JSON.stringify({
+          name: newKeyName,
+          scopes: newKeyScopes,
+          services: newKeyServices,
+          levels: newKeyLevels,
+          expiresInDays: newKeyExpiry || undefined,
+          dailyQuota: newKeyQuota || undefined,
+        }),
       })
       if (!res.ok) throw new Error("Failed to create key")
+      const created = await res.json()
       await mutate()
-      setOpen(false)
+      setCreatedKey(created.key)
       setNewKeyName("")
+      setNewKeyScopes(["ingest"])
+      setNewKeyServices("")
+      setNewKeyLevels("")
+      setNewKeyExpiry("")
+      setNewKeyQuota("")
       toast({
         title: "Success",
         description: "API Key created successfully",
@@ -67,6 +102,34 @@ export default function ApiKeysPage() {
     }
   }
 
+  const toggleScope = (scope: string) => {
+    setNewKeyScopes((scopes) =>
+      scopes.includes(scope) ? scopes.filter((s) => s !== scope) : [...scopes, scope]
+    )
+  }
+
+  const rotateKey = async (key: ApiKey) => {
+    if (!confirm(`Rotate "${key.name}"? The current key keeps working for 24 hours.`)) return
+    try {
+      const res = await fetch("/api/keys/rotate", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ id: key.id }),
+      })
+      if (!res.ok) throw new Error("Failed to rotate key")
+      const created = await res.json()
+      await mutate()
+      setCreatedKey(created.key)
+      setOpen(true)
+    } catch (error) {
+      toast({
+        title: "Error",
+        description: "Failed to rotate API key",
+        variant: "destructive",
+      })
+    }
+  }
+
   const deleteKey = async (id: string) => {
     if (!confirm("Are you sure you want to delete this API key?")) return
     try {
@@ -109,38 +172,146 @@ export default function ApiKeysPage() {
           <div>
             <h1 className="text-3xl font-bold tracking-tight">API Keys</h1>
             <p className="text-muted-foreground mt-2">
-              Manage authentication keys for sending logs to the Collector.
+              Manage authentication keys for sending logs to the Collector and reading them back.
             </p>
           </div>
-          <Dialog open={open} onOpenChange={setOpen}>
+          <Dialog
+            open={open}
+            onOpenChange={(value) => {
+              setOpen(value)
+              if (!value) setCreatedKey(null)
+            }}
+          >
             <DialogTrigger asChild>
               <Button className="gap-2 shadow-sm">
                 <Plus className="h-4 w-4" /> Create New Key
               </Button>
             </DialogTrigger>
             <DialogContent className="sm:max-w-[425px]">
-              <DialogHeader>
-                <DialogTitle>Create API Key</DialogTitle>
-                <DialogDescription>
-                  Give your key a name to identify the source (e.g., "Production Backend").
-                </DialogDescription>
-              </DialogHeader>
-              <div className="space-y-4 py-4">
-                <div className="space-y-2">
-                  <label htmlFor="name" className="text-sm font-medium leading-none peer-disabled:cursor-not-allowed peer-disabled:opacity-70">
-                    Key Name
-                  </label>
-                  <Input
-                    id="name"
-                    placeholder="e.g. My App Prod"
-                    value={newKeyName}
-                    onChange={(e) => setNewKeyName(e.target.value)}
-                  />
-                </div>
-                <Button onClick={createKey} disabled={creating} className="w-full">
-                  {creating ? <Loader2 className="h-4 w-4 animate-spin" /> : "Generate Key"}
-                </Button>
-              </div>
+              {createdKey ? (
+                <>
+                  <DialogHeader>
+                    <DialogTitle>Copy your API Key</DialogTitle>
+                    <DialogDescription>
+                      Store it somewhere safe now. For security it is not stored in plaintext and cannot be shown again.
+                    </DialogDescription>
+                  </DialogHeader>
+                  <div className="space-y-4 py-4">
+                    <div className="flex items-center gap-2">
+                      <div className="flex-1 bg-muted/50 rounded-md px-3 py-2.5 font-mono text-sm break-all border shadow-sm">
+                        {createdKey}
+                      </div>
+                      <Button
+                        variant="outline"
+                        size="icon"
+                        onClick={() => copyToClipboard(createdKey)}
+                        className="shrink-0 h-10 w-10"
+                      >
+                        <Copy className="h-4 w-4" />
+                      </Button>
+                    </div>
+                    <Button
+                      onClick={() => {
+                        setOpen(false)
+                        setCreatedKey(null)
+                      }}
+                      className="w-full"
+                    >
+                      Done
+                    </Button>
+                  </div>
+                </>
+              ) : (
+                <>
+                  <DialogHeader>
+                    <DialogTitle>Create API Key</DialogTitle>
+                    <DialogDescription>
+                      Give your key a name to identify the source (e.g., "Production Backend").
+                    </DialogDescription>
+                  </DialogHeader>
+                  <div className="space-y-4 py-4">
+                    <div className="space-y-2">
+                      <label htmlFor="name" className="text-sm font-medium leading-none peer-disabled:cursor-not-allowed peer-disabled:opacity-70">
+                        Key Name
+                      </label>
+                      <Input
+                        id="name"
+                        placeholder="e.g. My App Prod"
+                        value={newKeyName}
+                        onChange={(e) => setNewKeyName(e.target.value)}
+                      />
+                    </div>
+                    <div className="space-y-2">
+                      <span className="text-sm font-medium leading-none">Scopes</span>
+                      {SCOPES.map((scope) => (
+                        <label key={scope.value} className="flex items-start gap-2 text-sm">
+                          <input
+                            type="checkbox"
+                            className="mt-0.5"
+                            checked={newKeyScopes.includes(scope.value)}
+                            onChange={() => toggleScope(scope.value)}
+                          />
+                          <span>
+                            <span className="font-medium">{scope.label}</span>
+                            <span className="text-muted-foreground"> — {scope.description}</span>
+                          </span>
+                        </label>
+                      ))}
+                    </div>
+                    <div className="space-y-2">
+                      <label htmlFor="services" className="text-sm font-medium leading-none">
+                        Services <span className="text-muted-foreground font-normal">(optional)</span>
+                      </label>
+                      <Input
+                        id="services"
+                        placeholder="All services, or e.g. checkout,billing"
+                        value={newKeyServices}
+                        onChange={(e) => setNewKeyServices(e.target.value)}
+                      />
+                    </div>
+                    <div className="space-y-2">
+                      <label htmlFor="levels" className="text-sm font-medium leading-none">
+                        Levels <span className="text-muted-foreground font-normal">(optional)</span>
+                      </label>
+                      <Input
+                        id="levels"
+                        placeholder="All levels, or e.g. error,warn"
+                        value={newKeyLevels}
+                        onChange={(e) => setNewKeyLevels(e.target.value)}
+                      />
+                    </div>
+                    <div className="space-y-2">
+                      <label htmlFor="expiry" className="text-sm font-medium leading-none">
+                        Expires after <span className="text-muted-foreground font-normal">(days, optional)</span>
+                      </label>
+                      <Input
+                        id="expiry"
+                        type="number"
+                        min={1}
+                        placeholder="Never"
+                        value={newKeyExpiry}
+                        onChange={(e) => setNewKeyExpiry(e.target.value)}
+                      />
+                    </div>
+                    <div className="space-y-2">
+                      <label htmlFor="quota" className="text-sm font-medium leading-none">
+                        Daily quota <span className="text-muted-foreground font-normal">(log entries, optional)</span>
+                      </label>
+                      <Input
+                        id="quota"
+                        type="number"
+                        min={1}
+                        placeholder="Unlimited"
+                        value={newKeyQuota}
+                        onChange={(e) => setNewKeyQuota(e.target.value)}
+                      />
+                    </div>
+                    <Button onClick={createKey} disabled={creating || newKeyScopes.length === 0} className="w-full">
+                      {creating ? <Loader2 className="h-4 w-4 animate-spin" /> : "Generate Key"}
+                    </Button>
+                  </div>
+                </>
+              )}
             </DialogContent>
           </Dialog>
         </div>
@@ -175,31 +346,59 @@ export default function ApiKeysPage() {
                   </CardTitle>
                   <CardDescription>
                     Created on {format(new Date(key.createdAt), "MMMM dd, yyyy")}
+                    {key.expiresAt &&
+                      (new Date(key.expiresAt) < new Date()
+                        ? " · Expired"
+                        : ` · ${key.successorId ? "Rotated, expires" : "Expires"} ${formatDistanceToNow(new Date(key.expiresAt), { addSuffix: true })}`)}
                   </CardDescription>
-                </div>
-                <Button
-                  variant="ghost"
-                  size="icon"
-                  className="text-muted-foreground hover:text-red-600 hover:bg-red-50"
-                  onClick={() => deleteKey(key.id)}
-                >
-                  <Trash2 className="h-4 w-4" />
-                </Button>
-              </CardHeader>
-              <CardContent className="p-4">
-                <div className="flex items-center gap-2">
-                  <div className="flex-1 bg-muted/50 rounded-md px-3 py-2.5 font-mono text-sm truncate border shadow-sm">
-                    {key.key}
+                  <CardDescription>
+                    {key.lastUsedAt
+                      ? `Last used ${formatDistanceToNow(new Date(key.lastUsedAt), { addSuffix: true })}${key.lastUsedIp ? ` from ${key.lastUsedIp}` : ""} · ${key.requestCount.toLocaleString()} requests`
+                      : "Never used"}
+                  </CardDescription>
+                  <div className="flex flex-wrap gap-1 pt-1">
+                    {key.scopes.map((scope) => (
+                      <Badge key={scope} variant="outline" className="capitalize">
+                        {scope}
+                      </Badge>
+                    ))}
+                    {key.services.length > 0 && (
+                      <Badge variant="outline">services: {key.services.join(", ")}</Badge>
+                    )}
+                    {key.levels.length > 0 && (
+                      <Badge variant="outline">levels: {key.levels.join(", ")}</Badge>
+                    )}
+                    {key.dailyQuota !== null && (
+                      <Badge variant="outline">quota: {key.dailyQuota.toLocaleString()}/day</Badge>
+                    )}
                   </div>
+                </div>
+                <div className="flex gap-1">
+                  {!key.successorId && (
+                    <Button
+                      variant="ghost"
+                      size="icon"
+                      title="Rotate key"
+                      className="text-muted-foreground hover:text-primary"
+                      onClick={() => rotateKey(key)}
+                    >
+                      <RotateCw className="h-4 w-4" />
+                    </Button>
+                  )}
                   <Button
-                    variant="outline"
+                    variant="ghost"
                     size="icon"
-                    onClick={() => copyToClipboard(key.key)}
-                    className="shrink-0 h-10 w-10"
+                    className="text-muted-foreground hover:text-red-600 hover:bg-red-50"
+                    onClick={() => deleteKey(key.id)}
                   >
-                    <Copy className="h-4 w-4" />
+                    <Trash2 className="h-4 w-4" />
                   </Button>
                 </div>
+              </CardHeader>
+              <CardContent className="p-4">
+                <div className="bg-muted/50 rounded-md px-3 py-2.5 font-mono text-sm truncate border shadow-sm">
+                  {key.prefix ? `pk_${key.prefix}_••••••••` : "pk_••••••••"}
+                </div>
               </CardContent>
             </Card>
           ))
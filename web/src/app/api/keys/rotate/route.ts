@@ -0,0 +1,78 @@
+import { NextResponse } from "next/server"
+import { getServerSession } from "next-auth"
+import { prisma } from "@/lib/prisma"
+import { generateApiKey, parseExpiry, toResponse } from "@/lib/api-keys"
+
+const DEFAULT_GRACE_HOURS = 24
+
+// Rotating a key issues a successor with the same name, scopes,
+// restrictions and limits. The old key keeps working for the grace period so clients
+// can be switched over, then expires.
+export async function POST(req: Request) {
+  const session = await getServerSession()
+  if (!session || !session.user?.email) {
+    return NextResponse.json({ error: "Unauthorized" }, { status: 401 })
+  }
+
+  const { id, graceHours = DEFAULT_GRACE_HOURS, expiresInDays } = await req.json()
+  if (!id) {
+    return NextResponse.json({ error: "ID is required" }, { status: 400 })
+  }
+
+  const grace = Number(graceHours)
+  if (!Number.isFinite(grace) || grace < 0) {
+    return NextResponse.json({ error: "graceHours must be zero or more" }, { status: 400 })
+  }
+  const expiresAt = parseExpiry(expiresInDays)
+  if (expiresAt === undefined) {
+    return NextResponse.json({ error: "expiresInDays must be a positive number" }, { status: 400 })
+  }
+
+  const user = await prisma.user.findUnique({ where: { email: session.user.email } })
+  const old = await prisma.apiKey.findUnique({ where: { id } })
+  if (!old || !user || old.userId !== user.id) {
+    return NextResponse.json({ error: "Not found or unauthorized" }, { status: 403 })
+  }
+  if (old.successorId) {
+    return NextResponse.json({ error: "This key has already been rotated" }, { status: 409 })
+  }
+
+  const graceEnd = new Date(Date.now() + grace * 60 * 60 * 1000)
+  const { key, prefix, keyHash } = generateApiKey()
+
+  const successor = await prisma.$transaction(async (tx) => {
+    // A linked client certificate moves to the successor; it does not
+    // depend on the secret being rotated.
+    await tx.apiKey.update({ where: { id: old.id }, data: { certSubject: null } })
+
+    const created = await tx.apiKey.create({
+      data: {
+        name: old.name,
+        prefix,
+        keyHash,
+        userId: user.id,
+        active: old.active,
+        certSubject: old.certSubject,
+        scopes: old.scopes,
+        services: old.services,
+        levels: old.levels,
+        rateLimitEntries: old.rateLimitEntries,
+        rateLimitBytes: old.rateLimitBytes,
+        dailyQuota: old.dailyQuota,
+        expiresAt,
+      },
+    })
+
+    await tx.apiKey.update({
+      where: { id: old.id },
+      data: {
+        successorId: created.id,
+        expiresAt: old.expiresAt && old.expiresAt < graceEnd ? old.expiresAt : graceEnd,
+      },
+    })
+    return created
+  })
+
+  // As on creation, the full key is only ever shown in this response.
+  return NextResponse.json({ ...toResponse(successor), key })
+}
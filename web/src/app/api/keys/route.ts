@@ -1,12 +1,7 @@
 import { NextResponse } from "next/server"
 import { getServerSession } from "next-auth"
 import { prisma } from "@/lib/prisma"
-import { randomBytes } from "crypto"
-
-// Helper to generate a secure random key
-function generateApiKey() {
-  return `pk_${randomBytes(16).toString("hex")}`
-}
+import { LEVELS, SCOPES, generateApiKey, parseList, parseExpiry, parseLimit, toResponse } from "@/lib/api-keys"
 
 export async function GET() {
   const session = await getServerSession()
@@ -19,7 +14,7 @@ export async function GET() {
     include: { apiKeys: { orderBy: { createdAt: "desc" } } },
   })
 
-  return NextResponse.json(user?.apiKeys || [])
+  return NextResponse.json((user?.apiKeys || []).map(toResponse))
 }
 
 export async function POST(req: Request) {
@@ -28,25 +23,78 @@ export async function POST(req: Request) {
     return NextResponse.json({ error: "Unauthorized" }, { status: 401 })
   }
 
-  const { name } = await req.json()
+  const { name, certSubject, scopes, services, levels, expiresInDays, rateLimitEntries, rateLimitBytes, dailyQuota } =
+    await req.json()
   if (!name) {
     return NextResponse.json({ error: "Name is required" }, { status: 400 })
   }
 
+  const keyScopes = scopes === undefined ? ["ingest"] : parseList(scopes)
+  if (keyScopes.length === 0 || keyScopes.some((scope) => !SCOPES.includes(scope))) {
+    return NextResponse.json({ error: `Scopes must be one or more of ${SCOPES.join(", ")}` }, { status: 400 })
+  }
+
+  const keyLevels = parseList(levels)
+  const unknownLevel = keyLevels.find((level) => !LEVELS.includes(level.toLowerCase()))
+  if (unknownLevel !== undefined) {
+    return NextResponse.json({ error: `Unknown level "${unknownLevel}"` }, { status: 400 })
+  }
+
+  const expiresAt = parseExpiry(expiresInDays)
+  if (expiresAt === undefined) {
+    return NextResponse.json({ error: "expiresInDays must be a positive number" }, { status: 400 })
+  }
+
+  const limits = {
+    rateLimitEntries: parseLimit(rateLimitEntries),
+    rateLimitBytes: parseLimit(rateLimitBytes),
+    dailyQuota: parseLimit(dailyQuota),
+  }
+  if (Object.values(limits).some((limit) => limit === undefined)) {
+    return NextResponse.json({ error: "Rate limits and quotas must be positive numbers" }, { status: 400 })
+  }
+
   const user = await prisma.user.findUnique({ where: { email: session.user.email } })
   if (!user) {
     return NextResponse.json({ error: "User not found" }, { status: 404 })
   }
 
+  // Only a user who already holds a working admin key may grant admin. The
+  // first one is granted in the database (see DEPLOY.md).
+  if (keyScopes.includes("admin")) {
+    const adminKey = await prisma.apiKey.findFirst({
+      where: {
+        userId: user.id,
+        active: true,
+        scopes: { has: "admin" },
+        OR: [{ expiresAt: null }, { expiresAt: { gt: new Date() } }],
+      },
+    })
+    if (!adminKey) {
+      return NextResponse.json({ error: "Only admins may create admin keys" }, { status: 403 })
+    }
+  }
+
+  const { key, prefix, keyHash } = generateApiKey()
   const newKey = await prisma.apiKey.create({
     data: {
       name,
-      key: generateApiKey(),
+      prefix,
+      keyHash,
       userId: user.id,
+      certSubject: certSubject || null,
+      scopes: keyScopes,
+      services: parseList(services),
+      levels: keyLevels,
+      expiresAt,
+      rateLimitEntries: limits.rateLimitEntries,
+      rateLimitBytes: limits.rateLimitBytes,
+      dailyQuota: limits.dailyQuota === null ? null : BigInt(Math.floor(limits.dailyQuota!)),
     },
   })
 
-  return NextResponse.json(newKey)
+  // The full key is only ever shown in this response.
+  return NextResponse.json({ ...toResponse(newKey), key })
 }
 
 export async function DELETE(req: Request) {
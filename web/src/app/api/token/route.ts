@@ -0,0 +1,14 @@
+import { NextRequest, NextResponse } from "next/server"
+import { getToken } from "next-auth/jwt"
+
+// Hands the signed-in user's session token to the dashboard so it can call
+// the query API, which is on another origin and never sees our cookies. The
+// API decrypts it with the same NEXTAUTH_SECRET.
+export async function GET(req: NextRequest) {
+  const token = await getToken({ req, raw: true })
+  if (!token) {
+    return NextResponse.json({ error: "Unauthorized" }, { status: 401 })
+  }
+
+  return NextResponse.json({ token }, { headers: { "Cache-Control": "no-store" } })
+}
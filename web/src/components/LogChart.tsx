@@ -19,16 +19,19 @@ interface LogStats {
 
 interface LogChartProps {
   data: LogStats[]
+  interval?: string
 }
 
 import { motion } from "framer-motion"
 
-export function LogChart({ data }: LogChartProps) {
+export function LogChart({ data, interval }: LogChartProps) {
   const { theme } = useTheme()
   const isDark = theme === "dark"
 
+  // Daily buckets are labelled by date, shorter ones by time of day
+  const timeFormat = interval?.endsWith("d") ? "MMM d" : "HH:mm"
   const formattedData = data.map((item) => ({
-    time: format(new Date(item.timestamp), "HH:mm"),
+    time: format(new Date(item.timestamp), timeFormat),
     count: item.count,
   }))
 
@@ -1,6 +1,6 @@
 "use client"
 
-import { useState, useEffect } from "react"
+import { useState, useEffect, type ReactNode } from "react"
 import useSWR from "swr"
 import { format, subDays } from "date-fns"
 import { Activity, AlertCircle, RefreshCw, Search, Server, LogOut, Loader2, Key, Book } from "lucide-react"
@@ -28,20 +28,52 @@ import {
 } from "@/components/ui/dialog"
 
 interface LogEntry {
+  id?: string
   timestamp: string
   service: string
   level: string
   message: string
   metadata?: Record<string, string>
+  // UTF-16 [start, end) offsets of search matches in message
+  highlights?: [number, number][]
 }
 
-interface LogStats {
-  timestamp: string
-  count: number
+interface LogPage {
+  logs: LogEntry[]
+  next_cursor?: string
+  prev_cursor?: string
+}
+
+interface StatsResult {
+  interval: string
+  timezone: string
+  buckets: { timestamp: string; count: number }[]
+}
+
+// Wraps the parts of a message that matched the search in <mark>
+function highlightMessage(message: string, highlights?: [number, number][]) {
+  if (!highlights?.length) return message
+  const parts: ReactNode[] = []
+  let pos = 0
+  highlights.forEach(([start, end], i) => {
+    if (start < pos) return
+    parts.push(message.slice(pos, start))
+    parts.push(<mark key={i} className="bg-yellow-300/60 dark:bg-yellow-500/40 text-inherit rounded-sm">{message.slice(start, end)}</mark>)
+    pos = end
+  })
+  parts.push(message.slice(pos))
+  return parts
 }
 
 const fetcher = (url: string) => fetch(url).then((res) => res.json())
 
+// Calls to the query API carry the session token from /api/token.
+const apiFetcher = ([url, token]: [string, string]) =>
+  fetch(url, { headers: { Authorization: `Bearer ${token}` } }).then((res) => {
+    if (!res.ok) throw new Error(`API request failed: ${res.status}`)
+    return res.json()
+  })
+
 export default function LogDashboard() {
   const { data: session } = useSession()
   const [serviceFilter, setServiceFilter] = useState("")
@@ -53,6 +85,9 @@ export default function LogDashboard() {
   const [wsConnected, setWsConnected] = useState(false)
   const [realtimeLogs, setRealtimeLogs] = useState<LogEntry[]>([])
   const [isLogoutOpen, setIsLogoutOpen] = useState(false)
+  // Cursors of the older pages visited; empty shows the newest logs
+  const [cursors, setCursors] = useState<string[]>([])
+  const cursor = cursors[cursors.length - 1]
 
   // Query Params Construction
   const queryParams = new URLSearchParams({ limit: "100" })
@@ -61,21 +96,37 @@ export default function LogDashboard() {
   if (searchFilter) queryParams.set("search", searchFilter)
   if (dateRange?.from) queryParams.set("start_time", dateRange.from.toISOString())
   if (dateRange?.to) queryParams.set("end_time", dateRange.to.toISOString())
+  const logParams = new URLSearchParams(queryParams)
+  if (cursor) logParams.set("cursor", cursor)
+
+  // Start from the newest page whenever the filters change
+  useEffect(() => {
+    setCursors([])
+  }, [serviceFilter, levelFilter, searchFilter, dateRange])
+
+  // Session token for the query API; refreshed well before it expires
+  const { data: tokenData } = useSWR<{ token: string }>("/api/token", fetcher, {
+    refreshInterval: 5 * 60 * 1000,
+  })
+  const token = tokenData?.token
 
   // Fetch Historical Logs
   const apiUrl = process.env.NEXT_PUBLIC_API_URL || "http://localhost:8081"
-  const { data: historicalLogs, error, mutate } = useSWR<LogEntry[]>(
-    `${apiUrl}/logs?${queryParams.toString()}`,
-    fetcher,
+  const { data: logPage, error, mutate } = useSWR<LogPage>(
+    token ? [`${apiUrl}/logs?${logParams.toString()}`, token] : null,
+    apiFetcher,
     {
-      refreshInterval: isAutoRefresh && !wsConnected ? 2000 : 0,
+      refreshInterval: isAutoRefresh && !wsConnected && !cursor ? 2000 : 0,
     }
   )
 
   // Fetch Stats for Chart
-  const { data: stats } = useSWR<LogStats[]>(
-    `${apiUrl}/stats?${queryParams.toString()}`,
-    fetcher,
+  const statsParams = new URLSearchParams(queryParams)
+  statsParams.set("tz", Intl.DateTimeFormat().resolvedOptions().timeZone)
+  statsParams.set("envelope", "true")
+  const { data: stats } = useSWR<StatsResult>(
+    token ? [`${apiUrl}/stats?${statsParams.toString()}`, token] : null,
+    apiFetcher,
     {
       refreshInterval: 5000,
     }
@@ -83,10 +134,11 @@ export default function LogDashboard() {
 
   // WebSocket Integration
   useEffect(() => {
-    if (!isAutoRefresh) return
+    if (!isAutoRefresh || !token) return
 
+    // Browsers cannot set headers on WebSocket connections
     const wsUrl = process.env.NEXT_PUBLIC_WS_URL || "ws://localhost:8081"
-    const ws = new WebSocket(`${wsUrl}/ws`)
+    const ws = new WebSocket(`${wsUrl}/ws?access_token=${encodeURIComponent(token)}`)
 
     ws.onopen = () => {
       setWsConnected(true)
@@ -114,13 +166,13 @@ export default function LogDashboard() {
     return () => {
       ws.close()
     }
-  }, [isAutoRefresh])
+  }, [isAutoRefresh, token])
 
   // Merge Realtime and Historical Logs
   // Priority: Realtime logs (if connected) -> Historical logs
-  const displayLogs = wsConnected && realtimeLogs.length > 0 
+  const displayLogs = wsConnected && realtimeLogs.length > 0 && !cursor
     ? realtimeLogs 
-    : historicalLogs || []
+    : logPage?.logs || []
 
   // Animation Variants
   const container = {
@@ -256,7 +308,7 @@ export default function LogDashboard() {
         >
           <h3 className="text-lg font-semibold mb-4">Log Volume</h3>
           {stats ? (
-            <LogChart data={stats} />
+            <LogChart data={stats.buckets} interval={stats.interval} />
           ) : (
             <div className="h-[300px] flex items-center justify-center text-muted-foreground">
               <Loader2 className="w-8 h-8 animate-spin" />
@@ -339,7 +391,7 @@ export default function LogDashboard() {
               <AnimatePresence mode="popLayout">
                 {displayLogs.map((log, i) => (
                   <motion.tr
-                    key={`${log.timestamp}-${i}`}
+                    key={log.id ?? `${log.timestamp}-${i}`}
                     initial={{ opacity: 0, x: -20 }}
                     animate={{ opacity: 1, x: 0 }}
                     exit={{ opacity: 0, x: 20 }}
@@ -369,7 +421,7 @@ export default function LogDashboard() {
                       </div>
                     </td>
                     <td className="px-6 py-4 max-w-xl truncate text-foreground/80 group-hover:text-foreground transition-colors" title={log.message}>
-                      {log.message}
+                      {highlightMessage(log.message, log.highlights)}
                     </td>
                   </motion.tr>
                 ))}
@@ -387,6 +439,16 @@ export default function LogDashboard() {
             </tbody>
           </table>
         </div>
+        {(cursor || logPage?.next_cursor) && (
+          <div className="flex items-center justify-end gap-2 px-6 py-3 border-t">
+            <Button variant="outline" size="sm" disabled={!cursor} onClick={() => setCursors((prev) => prev.slice(0, -1))}>
+              Newer
+            </Button>
+            <Button variant="outline" size="sm" disabled={!logPage?.next_cursor} onClick={() => setCursors((prev) => [...prev, logPage!.next_cursor!])}>
+              Older
+            </Button>
+          </div>
+        )}
       </motion.div>
     </div>
   )
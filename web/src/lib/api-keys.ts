@@ -0,0 +1,60 @@
+import type { ApiKey } from "@prisma/client"
+import { createHash, randomBytes } from "crypto"
+
+// Keys look like pk_<prefix>_<secret>. Only the prefix is stored in the clear;
+// the collector finds the row by prefix and checks the salted hash, in the
+// format documented in shared/auth/apikey.go.
+export function generateApiKey() {
+  const prefix = randomBytes(4).toString("hex")
+  const key = `pk_${prefix}_${randomBytes(24).toString("hex")}`
+  const salt = randomBytes(16).toString("hex")
+  const digest = createHash("sha256").update(salt + key).digest("hex")
+  return { key, prefix, keyHash: `sha256:${salt}:${digest}` }
+}
+
+export const SCOPES = ["ingest", "read", "admin"]
+
+// The level names a key can be restricted to, matched without case. This is
+// the list in shared/logs/level.go.
+export const LEVELS = [
+  "trace", "verbose",
+  "debug", "dbg",
+  "info", "information", "notice",
+  "warn", "warning",
+  "error", "err",
+  "fatal", "critical", "crit", "panic", "alert", "emerg", "emergency",
+]
+
+// Accepts an array or a comma-separated string, dropping blanks.
+export function parseList(value: unknown): string[] {
+  const items = Array.isArray(value) ? value : typeof value === "string" ? value.split(",") : []
+  return items.map((item) => String(item).trim()).filter(Boolean)
+}
+
+// Turns an optional expiresInDays into a date: null when absent, undefined
+// when invalid.
+export function parseExpiry(days: unknown): Date | null | undefined {
+  if (days === undefined || days === null || days === "") return null
+  const n = Number(days)
+  if (!Number.isFinite(n) || n <= 0) return undefined
+  return new Date(Date.now() + n * 24 * 60 * 60 * 1000)
+}
+
+// Parses an optional positive limit: null when absent, undefined when invalid.
+export function parseLimit(value: unknown): number | null | undefined {
+  if (value === undefined || value === null || value === "") return null
+  const n = Number(value)
+  if (!Number.isFinite(n) || n <= 0) return undefined
+  return n
+}
+
+// Never send key material back, only what identifies the key. Keys that have
+// not been migrated yet still show their prefix.
+export function toResponse({ key, keyHash, ...rest }: ApiKey) {
+  return {
+    ...rest,
+    prefix: rest.prefix ?? key?.slice(3, 11) ?? null,
+    requestCount: Number(rest.requestCount),
+    dailyQuota: rest.dailyQuota === null ? null : Number(rest.dailyQuota),
+  }
+}